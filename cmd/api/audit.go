@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/tomasen/realip"
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// audit records a security event about userID, 0 when there isnt a known user. A
+// failed insert is logged but doesnt fail the request, the client did nothing wrong
+func (app *application) audit(r *http.Request, eventType string, userID int64, details map[string]string) {
+	event := &data.AuditEvent{
+		Type:      eventType,
+		IP:        realip.FromRequest(r),
+		UserAgent: r.UserAgent(),
+		RequestID: app.contextGetRequestID(r),
+		Details:   details,
+	}
+	if userID != 0 {
+		event.UserID = &userID
+	}
+
+	err := app.models.Audit.Insert(event)
+	if err != nil {
+		app.requestLogger(r).PrintError(err, map[string]string{
+			"audit_event": eventType,
+		})
+	}
+}
+
+// GET /v1/audit, needs audit:read which only admins are given
+func (app *application) listAuditHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.AuditFilter
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.AuditFilter = data.AuditFilter{
+		Type:   app.readString(qs, "type", ""),
+		UserID: app.readOptionalInt(qs, "user_id", v),
+		IP:     app.readString(qs, "ip", ""),
+		Since:  app.readTime(qs, "since", v),
+		Until:  app.readTime(qs, "until", v),
+	}
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	//always newest first
+	input.Filters.Sort = "-id"
+	input.Filters.SortSafelist = []string{"-id"}
+
+	data.ValidateAuditFilter(v, input.AuditFilter)
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	events, metadata, err := app.models.Audit.GetAll(input.AuditFilter, input.Filters)
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"events": events, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+	}
+}
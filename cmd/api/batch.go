@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// batch operation types and modes for POST /v1/movies/batch
+const (
+	batchCreate = "create"
+	batchUpdate = "update"
+	batchDelete = "delete"
+
+	batchAtomic  = "atomic"  //all or nothing, the default
+	batchPartial = "partial" //whatever succeeds is kept
+
+	maxBatchOperations = 500
+)
+
+type batchOperation struct {
+	Op      string          `json:"op"`
+	ID      int64           `json:"id"`
+	Version *int32          `json:"version"` //required for update and delete, like If-Match is on PATCH and DELETE
+	Movie   json.RawMessage `json:"movie"`   //create or update body, same as POST and PATCH take
+}
+
+type batchResult struct {
+	Index  int         `json:"index"`
+	Op     string      `json:"op"`
+	Status int         `json:"status"`
+	ID     int64       `json:"id,omitempty"`
+	Movie  *data.Movie `json:"movie,omitempty"`
+	Error  interface{} `json:"error,omitempty"` //string or field errors like the normal error responses
+}
+
+// batchError carries the status and message for one failed operation
+type batchError struct {
+	status  int
+	message interface{}
+}
+
+func (e *batchError) Error() string {
+	return fmt.Sprintf("batch operation failed with status %d", e.status)
+}
+
+// POST /v1/movies/batch, many creates, updates and deletes in one transaction
+func (app *application) batchMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Mode       string           `json:"mode"`
+		Operations []batchOperation `json:"operations"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.Mode == "" {
+		input.Mode = batchAtomic
+	}
+
+	v := validator.New()
+	v.Check(validator.In(input.Mode, batchAtomic, batchPartial), "mode", "must be atomic or partial")
+	v.Check(len(input.Operations) > 0, "operations", "must contain at least 1 operation")
+	v.Check(len(input.Operations) <= maxBatchOperations, "operations", fmt.Sprintf("must not contain more than %d operations", maxBatchOperations))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	knownGenres, err := app.models.Genres.Slugs()
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+		return
+	}
+
+	batch, err := app.movies(r).BeginBatch()
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+		return
+	}
+	defer batch.Rollback()
+
+	results := make([]*batchResult, len(input.Operations))
+	failed := false
+	for i, op := range input.Operations {
+		result := &batchResult{Index: i, Op: op.Op}
+		results[i] = result
+
+		err := batch.Step(func() error {
+			return app.runBatchOperation(batch, op, knownGenres, result)
+		})
+
+		var opErr *batchError
+		switch {
+		case err == nil:
+		case errors.As(err, &opErr):
+			failed = true
+			result.Status, result.Error = opErr.status, opErr.message
+		default:
+			//not the clients fault, but the savepoint kept the rest of the batch usable
+			app.logError(r, err)
+			failed = true
+			result.Status = http.StatusInternalServerError
+			result.Error = "Server encountered a problem and could not process this operation"
+		}
+	}
+
+	if failed && input.Mode == batchAtomic {
+		//nothing is kept, mark the operations that worked as not applied
+		for _, result := range results {
+			if result.Error == nil {
+				result.Status = http.StatusFailedDependency
+				result.Movie = nil
+				result.Error = "not applied, another operation in the batch failed"
+			}
+		}
+		err = app.writeResponse(w, r, http.StatusUnprocessableEntity, envelope{"error": "batch failed, no operations were applied", "results": results}, nil)
+		if err != nil {
+			app.serverErrorReponse(w, r, err)
+		}
+		return
+	}
+
+	err = batch.Commit()
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+		return
+	}
+	for _, result := range results {
+		if result.Op == batchDelete && result.Error == nil {
+			app.similar.Remove(result.ID)
+		}
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"results": results}, nil)
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+	}
+}
+
+// runBatchOperation does one operation and fills in result, client mistakes come
+// back as *batchError and anything else is a server error
+func (app *application) runBatchOperation(batch *data.MovieBatch, op batchOperation, knownGenres []string, result *batchResult) error {
+	switch op.Op {
+	case batchCreate:
+		var input createMovieInput
+		err := decodeBatchMovie(op.Movie, &input)
+		if err != nil {
+			return err
+		}
+		movie := input.movie()
+
+		v := validator.New()
+		if data.ValidateMovie(v, movie, knownGenres); !v.Valid() {
+			return &batchError{http.StatusUnprocessableEntity, v.Errors}
+		}
+		err = batch.Insert(movie)
+		if err != nil {
+			return err
+		}
+		result.Status, result.ID, result.Movie = http.StatusCreated, movie.ID, movie
+		return nil
+
+	case batchUpdate:
+		result.ID = op.ID
+		var input updateMovieInput
+		err := decodeBatchMovie(op.Movie, &input)
+		if err != nil {
+			return err
+		}
+		movie, err := batchGet(batch, op)
+		if err != nil {
+			return err
+		}
+		input.apply(movie)
+
+		v := validator.New()
+		if data.ValidateMovie(v, movie, knownGenres); !v.Valid() {
+			return &batchError{http.StatusUnprocessableEntity, v.Errors}
+		}
+		err = batch.Update(movie)
+		if err != nil {
+			return err
+		}
+		result.Status, result.Movie = http.StatusOK, movie
+		return nil
+
+	case batchDelete:
+		result.ID = op.ID
+		_, err := batchGet(batch, op)
+		if err != nil {
+			return err
+		}
+		err = batch.Delete(op.ID)
+		if err != nil {
+			return err
+		}
+		result.Status = http.StatusOK
+		return nil
+	}
+
+	return &batchError{http.StatusUnprocessableEntity, map[string]string{"op": "must be create, update or delete"}}
+}
+
+// batchGet locks the movie an update or delete is for and checks its version
+func batchGet(batch *data.MovieBatch, op batchOperation) (*data.Movie, error) {
+	if op.Version == nil {
+		return nil, &batchError{http.StatusPreconditionRequired, map[string]string{"version": "must be provided for update and delete"}}
+	}
+	movie, err := batch.Get(op.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, &batchError{http.StatusNotFound, "The requested resource could not be found"}
+		default:
+			return nil, err
+		}
+	}
+	if *op.Version != movie.Version {
+		return nil, &batchError{http.StatusConflict, "unable to update the record due to a edit conflict, try once more in a sec"}
+	}
+	return movie, nil
+}
+
+// decodeBatchMovie decodes an operations movie body as strictly as readJSON does.
+// Problems come back by field, like "movie.year", rather than as decoder errors
+func decodeBatchMovie(raw json.RawMessage, dst interface{}) error {
+	raw = bytes.TrimSpace(raw)
+	switch {
+	case len(raw) == 0 || bytes.Equal(raw, []byte("null")):
+		return &batchError{http.StatusBadRequest, map[string]string{"movie": "must be provided"}}
+	case raw[0] != '{':
+		return &batchError{http.StatusBadRequest, map[string]string{"movie": "must be a JSON object"}}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(dst)
+	if err != nil {
+		return &batchError{http.StatusBadRequest, batchMovieErrors(err)}
+	}
+	//same as readJSON, one object and nothing after it
+	if dec.Decode(&struct{}{}) != io.EOF {
+		return &batchError{http.StatusBadRequest, map[string]string{"movie": "must be a single JSON object"}}
+	}
+	return nil
+}
+
+// batchMovieErrors turns a decoding error into a message for the field it was about
+func batchMovieErrors(err error) map[string]string {
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &typeError) && typeError.Field != "":
+		return map[string]string{"movie." + typeError.Field: "must be " + jsonTypeName(typeError.Type)}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return map[string]string{"movie." + field: "is not a movie field"}
+	case errors.Is(err, data.ErrInvalidRuntimeFormat):
+		return map[string]string{"movie.runtime": `must be a string like "102 mins"`}
+	case errors.As(err, &syntaxError), errors.Is(err, io.ErrUnexpectedEOF):
+		return map[string]string{"movie": "contains badly-formed JSON"}
+	}
+	return map[string]string{"movie": "could not be read"}
+}
+
+// jsonTypeName is how a Go type is written in JSON, with an article for messages
+func jsonTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a whole number"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	}
+	return "an object"
+}
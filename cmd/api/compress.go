@@ -0,0 +1,269 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"greenlight.alexedwards.net/internal/zstd"
+)
+
+// compressWriter is what a content coding needs, gzip.Writer, flate.Writer and
+// zstd.Writer all fit. Reset lets writers be pooled, they are expensive to make
+type compressWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// compressor is one Content-Encoding we can send
+type compressor struct {
+	encoding string
+	pool     *sync.Pool
+}
+
+func newCompressor(encoding string, newWriter func() compressWriter) compressor {
+	return compressor{
+		encoding: encoding,
+		pool:     &sync.Pool{New: func() interface{} { return newWriter() }},
+	}
+}
+
+// compressors in order of preference, ties in Accept-Encoding go to the first one.
+// zstd comes out well ahead of gzip on our JSON
+var compressors = []compressor{
+	newCompressor("zstd", func() compressWriter {
+		return zstd.NewWriter(io.Discard)
+	}),
+	newCompressor("gzip", func() compressWriter {
+		w, _ := gzip.NewWriterLevel(io.Discard, gzip.DefaultCompression)
+		return w
+	}),
+	newCompressor("deflate", func() compressWriter {
+		w, _ := flate.NewWriter(io.Discard, flate.DefaultCompression)
+		return w
+	}),
+}
+
+// compressible content types, images and the like are compressed already
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/x-ndjson", "application/msgpack", "application/xml", "application/javascript":
+		return true
+	}
+	return false
+}
+
+// negotiateCompressor picks from Accept-Encoding, nil for none. Same rules as
+// Accept: highest q, then the order of compressors, * matches any of ours
+func negotiateCompressor(header string) *compressor {
+	var best *compressor
+	bestQ := 0.0
+	for i := range compressors {
+		c := &compressors[i]
+		q, matched := 0.0, false
+		for _, part := range strings.Split(header, ",") {
+			coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding != c.encoding && (coding != "*" || matched) {
+				continue
+			}
+			codingQ := 1.0
+			if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+				f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err != nil {
+					continue
+				}
+				codingQ = f
+			}
+			//an exact match beats *
+			q, matched = codingQ, coding == c.encoding
+		}
+		if q > bestQ {
+			best, bestQ = c, q
+		}
+	}
+	return best
+}
+
+// compress encodes responses with zstd, gzip or deflate when the client allows it.
+// It has to sit inside metrics so the byte counts there are what actually went out.
+// Bodies are held back until there are minSize bytes, smaller ones arent worth it
+func (app *application) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.compression.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+		//whether we compress depends on Accept-Encoding, even when we dont
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		c := negotiateCompressor(r.Header.Get("Accept-Encoding"))
+		if c == nil || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressResponseWriter{
+			ResponseWriter: w,
+			compressor:     c,
+			minSize:        app.config.compression.minSize,
+		}
+
+		//our ETags have the coding added to them, take it back off so the
+		//handlers If-None-Match check sees the tag it made. A 304 has to carry
+		//the tag the client has cached, so remember if it was a compressed one
+		if inm := r.Header.Get("If-None-Match"); inm != "" {
+			stripped := strings.ReplaceAll(inm, "-"+c.encoding+`"`, `"`)
+			cw.cachedCompressed = stripped != inm
+			r.Header.Set("If-None-Match", stripped)
+		}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressResponseWriter buffers the start of a body and then either sends it
+// compressed or as it is
+type compressResponseWriter struct {
+	http.ResponseWriter
+	compressor *compressor
+	minSize    int
+
+	status           int
+	cachedCompressed bool //If-None-Match named a compressed representation
+	wroteHeader      bool //WriteHeader was called on us
+	started          bool //headers have gone to the client
+	buf              []byte
+	writer           compressWriter //nil unless compressing
+}
+
+func (cw *compressResponseWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = status
+	//no body coming, nothing to decide. We cant tell how big the body a 304 stands
+	//for is, so it gets whichever tag the client sent
+	if status < 200 || status == http.StatusNoContent || status == http.StatusNotModified {
+		if status == http.StatusNotModified && cw.cachedCompressed {
+			cw.tagETag()
+		}
+		cw.started = true
+		cw.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (cw *compressResponseWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.started {
+		if cw.writer != nil {
+			return cw.writer.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= cw.minSize {
+		err := cw.start(true)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// start sends the headers and whatever is buffered, compressed if big is set and
+// the response is something worth compressing
+func (cw *compressResponseWriter) start(big bool) error {
+	cw.started = true
+	h := cw.Header()
+	if big && h.Get("Content-Encoding") == "" && h.Get("Content-Range") == "" &&
+		cw.status != http.StatusPartialContent && compressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", cw.compressor.encoding)
+		h.Del("Content-Length")
+		cw.tagETag()
+		cw.writer = cw.compressor.pool.Get().(compressWriter)
+		cw.writer.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.writer != nil {
+		_, err = cw.writer.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// tagETag adds the coding to a strong ETag, a compressed body is a different
+// representation. The version part that If-Match reads is left alone
+func (cw *compressResponseWriter) tagETag() {
+	etag := cw.Header().Get("ETag")
+	if etag == "" || strings.HasPrefix(etag, "W/") || !strings.HasSuffix(etag, `"`) {
+		return
+	}
+	cw.Header().Set("ETag", strings.TrimSuffix(etag, `"`)+"-"+cw.compressor.encoding+`"`)
+}
+
+// Flush sends what we have so far, streaming handlers like the export use it. Once
+// a handler flushes we commit to compressing, however little has been written
+func (cw *compressResponseWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.started {
+		if err := cw.start(true); err != nil {
+			return
+		}
+	}
+	if cw.writer != nil {
+		cw.writer.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// close finishes the body, a response that never reached minSize goes out as it is
+func (cw *compressResponseWriter) close() {
+	if !cw.wroteHeader {
+		//handler wrote nothing at all, leave the default 200 to net/http
+		return
+	}
+	if !cw.started {
+		cw.start(false)
+	}
+	if cw.writer != nil {
+		cw.writer.Close()
+		cw.writer.Reset(io.Discard)
+		cw.compressor.pool.Put(cw.writer)
+		cw.writer = nil
+	}
+}
+
+// Unwrap lets http.ResponseController reach the real writer, for write deadlines
+func (cw *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
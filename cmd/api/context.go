@@ -15,6 +15,12 @@ type contextKey string
 // obj is to getting and setting user info in req context
 const userContextKey = contextKey("user")
 
+// request id from the requestID middleware, ties log lines and errors to a request
+const requestIDContextKey = contextKey("request_id")
+
+// set when the response is stored for Idempotency-Key replays
+const replayableContextKey = contextKey("replayable")
+
 // add struct to the context
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
@@ -29,3 +35,26 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+// add request id to the context
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// request id for r, empty if the requestID middleware hasnt run like in background jobs
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+// mark the response to r as one that gets replayed to later requests
+func (app *application) contextSetReplayable(r *http.Request) *http.Request {
+	ctx := context.WithValue(r.Context(), replayableContextKey, true)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextIsReplayable(r *http.Request) bool {
+	replayable, _ := r.Context().Value(replayableContextKey).(bool)
+	return replayable
+}
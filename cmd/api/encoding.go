@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"greenlight.alexedwards.net/internal/msgpack"
+)
+
+// responseEncoder is a body format clients can pick with Accept
+type responseEncoder struct {
+	contentType string
+	mediaTypes  []string //what the client can ask for, contentType first
+	tabular     bool     //only works for envelopes holding a list
+	encode      func(data envelope, pretty bool) ([]byte, error)
+}
+
+// responseEncoders in order of preference, JSON wins ties and is what clients that
+// send no Accept at all get
+var responseEncoders = []responseEncoder{
+	{
+		contentType: "application/json",
+		mediaTypes:  []string{"application/json"},
+		encode:      encodeJSON,
+	},
+	{
+		contentType: "application/msgpack",
+		mediaTypes:  []string{"application/msgpack", "application/vnd.msgpack", "application/x-msgpack"},
+		encode:      encodeMsgpack,
+	},
+	{
+		contentType: "text/csv; charset=utf-8",
+		mediaTypes:  []string{"text/csv"},
+		tabular:     true,
+		encode:      encodeCSV,
+	},
+}
+
+var errNotAcceptable = errors.New("no acceptable response encoding")
+
+// requestDecoders turn request bodies in other formats into JSON for readJSON, by
+// Content-Type. Anything not in here is read as JSON like it always was
+var requestDecoders = map[string]func(body []byte) ([]byte, error){
+	"application/msgpack":     msgpack.ToJSON,
+	"application/vnd.msgpack": msgpack.ToJSON,
+	"application/x-msgpack":   msgpack.ToJSON,
+}
+
+// writeResponse encodes data in the format the client asked for with Accept and
+// sends it. A GET from a client that accepts none of ours gets a 406
+func (app *application) writeResponse(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+	body, contentType, err := app.encodeResponse(r, data)
+	if errors.Is(err, errNotAcceptable) {
+		app.notAcceptableResponse(w, r)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	writeBody(w, status, contentType, body, headers)
+	return nil
+}
+
+// encodeResponse picks the encoder for r and runs it, errNotAcceptable if there isnt
+// one. Only GET and HEAD get that far, by the time anything else responds the change
+// is done and a 406 would make the client think it wasnt, so those fall back to JSON
+func (app *application) encodeResponse(r *http.Request, data envelope) ([]byte, string, error) {
+	enc, err := negotiateEncoder(r.Header.Get("Accept"), data)
+	if errors.Is(err, errNotAcceptable) && r.Method != http.MethodGet && r.Method != http.MethodHead {
+		enc, err = &responseEncoders[0], nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	body, err := enc.encode(data, wantsPretty(r))
+	if err != nil {
+		return nil, "", err
+	}
+	return body, enc.contentType, nil
+}
+
+// writeBody sends an already encoded body
+func writeBody(w http.ResponseWriter, status int, contentType string, body []byte, headers http.Header) {
+	// include headers and map to http.responsewriter map
+	// Its ok if header is nul, no errors here
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+	//the body depends on Accept so caches have to key on it
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// ?pretty or ?pretty=true indents JSON, compact otherwise
+func wantsPretty(r *http.Request) bool {
+	qs := r.URL.Query()
+	if !qs.Has("pretty") {
+		return false
+	}
+	pretty, err := strconv.ParseBool(qs.Get("pretty"))
+	return err != nil || pretty
+}
+
+// mediaRange is one entry of an Accept header
+type mediaRange struct {
+	mediaType string
+	q         float64
+}
+
+func parseAccept(header string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(s, 64)
+			if err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, mediaRange{mediaType: mediaType, q: q})
+	}
+	return ranges
+}
+
+// specificity is how closely a range matches mediaType, 2 exact, 1 type/*, 0 */*
+// and -1 for no match
+func (m mediaRange) specificity(mediaType string) int {
+	switch {
+	case m.mediaType == mediaType:
+		return 2
+	case m.mediaType == "*/*":
+		return 0
+	case strings.HasSuffix(m.mediaType, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(m.mediaType, "*")):
+		return 1
+	}
+	return -1
+}
+
+// negotiateEncoder picks the encoder with the highest q in accept. Each encoder gets
+// the q of the most specific range that matches it, ties go to the more specific
+// match and then to the order of responseEncoders
+func negotiateEncoder(accept string, data envelope) (*responseEncoder, error) {
+	if strings.TrimSpace(accept) == "" {
+		return &responseEncoders[0], nil
+	}
+	ranges := parseAccept(accept)
+
+	var best *responseEncoder
+	bestQ, bestSpecificity := 0.0, -1
+	for i := range responseEncoders {
+		enc := &responseEncoders[i]
+		if enc.tabular && tabularList(data) == nil {
+			continue
+		}
+		q, specificity := 0.0, -1
+		for _, mediaType := range enc.mediaTypes {
+			for _, m := range ranges {
+				if s := m.specificity(mediaType); s > specificity {
+					q, specificity = m.q, s
+				}
+			}
+		}
+		if q > bestQ || (q == bestQ && q > 0 && specificity > bestSpecificity) {
+			best, bestQ, bestSpecificity = enc, q, specificity
+		}
+	}
+	if best == nil {
+		return nil, errNotAcceptable
+	}
+	return best, nil
+}
+
+// acceptsMediaType reports whether the Accept header lists mediaType with a q above
+// zero. Wildcards dont count, only clients that ask for it by name get it
+func acceptsMediaType(r *http.Request, mediaType string) bool {
+	for _, m := range parseAccept(r.Header.Get("Accept")) {
+		if m.mediaType == mediaType && m.q > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func encodeJSON(data envelope, pretty bool) ([]byte, error) {
+	var js []byte
+	var err error
+	if pretty {
+		//using no line prefix "" and tab indents \t for each element returned
+		js, err = json.MarshalIndent(data, "", "\t")
+	} else {
+		js, err = json.Marshal(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// append newline to make easy to read in terminal
+	return append(js, '\n'), nil
+}
+
+// encodeMsgpack goes through JSON first so the custom MarshalJSON methods, like
+// Runtime and projected fields, look the same in both
+func encodeMsgpack(data envelope, pretty bool) ([]byte, error) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return msgpack.FromJSON(js)
+}
+
+// tabularList returns the one list in data, nil if there are none or several.
+// Everything else in the envelope, like metadata, is left out of the CSV
+func tabularList(data envelope) interface{} {
+	var list interface{}
+	for _, value := range data {
+		v := reflect.ValueOf(value)
+		if v.Kind() != reflect.Slice || v.Type().Elem().Kind() == reflect.Uint8 {
+			continue
+		}
+		if list != nil {
+			return nil
+		}
+		list = value
+	}
+	return list
+}
+
+// encodeCSV writes one row per list item with a header row. Columns are every key
+// seen, in the order they first show up. Lists of plain values are joined with
+// csvListSeparator like the export, nested objects go in as JSON
+func encodeCSV(data envelope, pretty bool) ([]byte, error) {
+	js, err := json.Marshal(tabularList(data))
+	if err != nil {
+		return nil, err
+	}
+	var items []json.RawMessage
+	err = json.Unmarshal(js, &items)
+	if err != nil {
+		return nil, err
+	}
+
+	var columns []string
+	seen := map[string]bool{}
+	rows := make([]map[string]string, len(items))
+	for i, item := range items {
+		keys, values, err := csvFields(item)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if !seen[key] {
+				seen[key] = true
+				columns = append(columns, key)
+			}
+		}
+		rows[i] = values
+	}
+
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	cw.Write(columns)
+	record := make([]string, len(columns))
+	for _, row := range rows {
+		for i, column := range columns {
+			record[i] = row[column]
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return buf.Bytes(), cw.Error()
+}
+
+// csvFields flattens one list item into CSV cells keeping its key order, an item
+// that isnt an object becomes a single "value" column
+func csvFields(item json.RawMessage) ([]string, map[string]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(item))
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, nil, err
+	}
+	if tok != json.Delim('{') {
+		cell, err := csvCell(item)
+		return []string{"value"}, map[string]string{"value": cell}, err
+	}
+
+	var keys []string
+	values := map[string]string{}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		key, _ := tok.(string)
+		var raw json.RawMessage
+		err = dec.Decode(&raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		values[key], err = csvCell(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, values, nil
+}
+
+func csvCell(raw json.RawMessage) (string, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	err := dec.Decode(&v)
+	if err != nil {
+		return "", err
+	}
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			switch item := item.(type) {
+			case string:
+				parts = append(parts, item)
+			case json.Number:
+				parts = append(parts, item.String())
+			default:
+				//not a flat list, leave it as JSON
+				return compactJSON(raw)
+			}
+		}
+		return strings.Join(parts, csvListSeparator), nil
+	default:
+		return compactJSON(raw)
+	}
+}
+
+func compactJSON(raw json.RawMessage) (string, error) {
+	var buf bytes.Buffer
+	err := json.Compact(&buf, raw)
+	return buf.String(), err
+}
+
+// readBody reads a request body in any format requestDecoders knows as JSON
+func readBody(w http.ResponseWriter, r *http.Request, maxBytes int64) (io.Reader, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	decode, ok := requestDecoders[mediaType]
+	if !ok {
+		return r.Body, nil
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) == 0 {
+		return bytes.NewReader(nil), nil
+	}
+	js, err := decode(body)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(js), nil
+}
@@ -3,13 +3,16 @@ package main
 //Used for Status codes and some logging
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 )
 
 // Helper for logging error message
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.PrintError(err, map[string]string{
+	app.requestLogger(r).PrintError(err, map[string]string{
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
 	})
@@ -17,15 +20,97 @@ func (app *application) logError(r *http.Request, err error) {
 
 // The error messages to client with given error code
 
+// message is a string or the validator errors map. Clients that Accept
+// application/problem+json get a RFC 9457 problem, everyone else {"error": message}
+// in whatever encoding they asked for, JSON if we dont have it. Both carry the request
+// ID so a client reporting an error can point us at the log lines, unless the body
+// is stored for Idempotency-Key replays
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
+	if acceptsMediaType(r, problemContentType) {
+		app.problemResponse(w, r, status, message)
+		return
+	}
+
 	env := envelope{"error": message}
+	if id := app.errorRequestID(r, status); id != "" {
+		env["request_id"] = id
+	}
 
-	//write response sig writejson helper, if error returned log it
-	err := app.writeJSON(w, status, env, nil)
+	body, contentType, err := app.encodeResponse(r, env)
+	if errors.Is(err, errNotAcceptable) {
+		body, err = encodeJSON(env, wantsPretty(r))
+		contentType = responseEncoders[0].contentType
+	}
+	//if error returned log it
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(500)
+		return
 	}
+	writeBody(w, status, contentType, body, nil)
+}
+
+// errorRequestID is the request ID to put in an error body. A body stored for
+// Idempotency-Key replays gets none, it would name the first request on every
+// replay. The X-Request-ID header always has the current one
+func (app *application) errorRequestID(r *http.Request, status int) string {
+	if app.contextIsReplayable(r) && idempotentStored(status) {
+		return ""
+	}
+	return app.contextGetRequestID(r)
+}
+
+const problemContentType = "application/problem+json"
+
+// problem is a RFC 9457 problem details body. We dont have pages documenting our
+// errors so type is always about:blank and title is the status text
+type problem struct {
+	Type     string         `json:"type"`
+	Title    string         `json:"title"`
+	Status   int            `json:"status"`
+	Detail   string         `json:"detail,omitempty"`
+	Instance string         `json:"instance,omitempty"` //the request ID
+	Errors   []problemField `json:"errors,omitempty"`
+}
+
+// problemField is one entry from validator.Validator.Errors
+type problemField struct {
+	Field  string `json:"field"`
+	Detail string `json:"detail"`
+}
+
+func (app *application) problemResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
+	p := problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Instance: app.errorRequestID(r, status),
+	}
+	switch message := message.(type) {
+	case map[string]string:
+		p.Detail = "one or more fields are invalid"
+		for field, detail := range message {
+			p.Errors = append(p.Errors, problemField{Field: field, Detail: detail})
+		}
+		//map order is random, keep the body stable
+		sort.Slice(p.Errors, func(i, j int) bool { return p.Errors[i].Field < p.Errors[j].Field })
+	default:
+		p.Detail = fmt.Sprint(message)
+	}
+
+	var js []byte
+	var err error
+	if wantsPretty(r) {
+		js, err = json.MarshalIndent(p, "", "\t")
+	} else {
+		js, err = json.Marshal(p)
+	}
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+		return
+	}
+	writeBody(w, status, problemContentType, append(js, '\n'), nil)
 }
 
 // servererrorreponse used when app find problems at runtime, logs detailed message
@@ -65,6 +150,29 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
+// 412 when the If-Match version is stale
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the record has changed since you fetched it, get it again and retry with the new ETag"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+// 406 when Accept rules out every encoding we have, see responseEncoders
+func (app *application) notAcceptableResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the Accept header doesnt allow any format this resource can be sent in"
+	app.errorResponse(w, r, http.StatusNotAcceptable, message)
+}
+
+// 409 when a JSON Patch doesnt fit the record, a test op failed or a path is missing
+func (app *application) patchConflictResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorResponse(w, r, http.StatusConflict, err.Error())
+}
+
+// 428 when a write needs If-Match and didnt send one
+func (app *application) preconditionRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this request must include an If-Match header with the record's ETag"
+	app.errorResponse(w, r, http.StatusPreconditionRequired, message)
+}
+
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded"
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
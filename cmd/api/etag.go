@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Movie ETags are "<version>-<body hash>". The hash part changes with anything in
+// the response, like embedded release dates, so If-None-Match is exact. If-Match
+// only looks at the version part since thats what PATCH and DELETE can conflict on
+
+// bodyETag tags a response by its bytes alone, for lists
+func bodyETag(js []byte) string {
+	return `"` + shortHash(js) + `"`
+}
+
+// movieETag returns a tag func for writeResponseTagged for a single movie
+func movieETag(version int32) func(js []byte) string {
+	return func(js []byte) string {
+		return fmt.Sprintf(`"%d-%s"`, version, shortHash(js))
+	}
+}
+
+func shortHash(js []byte) string {
+	sum := sha256.Sum256(js)
+	return hex.EncodeToString(sum[:8])
+}
+
+// writeResponseTagged is writeResponse with an ETag made from the encoded body, so
+// each encoding has its own tag. GETs that send a matching If-None-Match get a 304
+// with no body instead
+func (app *application) writeResponseTagged(w http.ResponseWriter, r *http.Request, status int, data envelope, tag func(js []byte) string) error {
+	body, contentType, err := app.encodeResponse(r, data)
+	if errors.Is(err, errNotAcceptable) {
+		app.notAcceptableResponse(w, r)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	etag := tag(body)
+	w.Header().Set("ETag", etag)
+
+	if (r.Method == http.MethodGet || r.Method == http.MethodHead) && noneMatch(r.Header.Get("If-None-Match"), etag) {
+		w.Header().Add("Vary", "Accept")
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	writeBody(w, status, contentType, body, nil)
+	return nil
+}
+
+// noneMatch reports whether an If-None-Match header matches etag. It uses weak
+// comparison, so W/ prefixes are ignored
+func noneMatch(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch enforces If-Match on a write to a movie at version. It writes the
+// 428 or 412 response itself and returns false when the request should stop
+func (app *application) checkIfMatch(w http.ResponseWriter, r *http.Request, version int32) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		app.preconditionRequiredResponse(w, r)
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		//strong comparison, weak tags never match
+		if !strings.HasPrefix(tag, `"`) || !strings.HasSuffix(tag, `"`) || len(tag) < 2 {
+			continue
+		}
+		v, _, _ := strings.Cut(strings.Trim(tag, `"`), "-")
+		if tagVersion, err := strconv.ParseInt(v, 10, 32); err == nil && int32(tagVersion) == version {
+			return true
+		}
+	}
+	app.preconditionFailedResponse(w, r)
+	return false
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// catalogue file formats for export and import
+const (
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+)
+
+// movieCSVColumns is the CSV header for exports. Lists are joined with | and
+// certifications/external_ids are JSON objects
+var movieCSVColumns = []string{
+	"id", "title", "year", "runtime", "genres", "version", "synopsis", "original_title",
+	"original_language", "spoken_languages", "certifications", "external_ids",
+	"poster_url", "poster_thumbnail_url",
+}
+
+// csvListSeparator joins genres and spoken languages in one CSV field
+const csvListSeparator = "|"
+
+// flush to the client every this many rows so big exports start arriving straight away
+const exportFlushEvery = 100
+
+// how long an export can take to send, instead of the servers WriteTimeout
+const exportTimeout = 10 * time.Minute
+
+// GET /v1/movies/export?format=csv|ndjson, the whole catalogue or whatever the
+// usual list filters match, streamed in id order
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	format := app.readString(qs, "format", formatNDJSON)
+	filter := app.readMovieFilter(qs, v)
+
+	v.Check(validator.In(format, formatCSV, formatNDJSON), "format", "must be csv or ndjson")
+	if data.ValidateMovieFilter(v, filter); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//the servers WriteTimeout is too short for a big export. Every writer in the
+	//middleware chain has to Unwrap for this to reach the connection
+	rc := http.NewResponseController(w)
+	err := rc.SetWriteDeadline(time.Now().Add(exportTimeout))
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+		return
+	}
+
+	var write func(movie *data.Movie) error
+	var flush func() error
+	csvWriter := csv.NewWriter(w)
+	switch format {
+	case formatCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		write = func(movie *data.Movie) error {
+			return csvWriter.Write(movieCSVRecord(movie))
+		}
+		flush = func() error {
+			csvWriter.Flush()
+			return csvWriter.Error()
+		}
+	default:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		write = func(movie *data.Movie) error {
+			return enc.Encode(movie)
+		}
+		flush = func() error { return nil }
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="movies.`+format+`"`)
+
+	//headers go out with the first row, until then a failure can still be a normal error response
+	started := false
+	start := func() error {
+		started = true
+		w.WriteHeader(http.StatusOK)
+		if format == formatCSV {
+			return csvWriter.Write(movieCSVColumns)
+		}
+		return nil
+	}
+
+	rows := 0
+	err = app.models.Movies.Export(filter, func(movie *data.Movie) error {
+		if !started {
+			err := start()
+			if err != nil {
+				return err
+			}
+		}
+		err := write(movie)
+		if err != nil {
+			return err
+		}
+		rows++
+		if rows%exportFlushEvery == 0 {
+			err = flush()
+			if err != nil {
+				return err
+			}
+			err = rc.Flush()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil && !started {
+		err = start()
+	}
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		if !started {
+			app.serverErrorReponse(w, r, err)
+			return
+		}
+		//too late for an error response, the client sees a cut off file
+		app.logError(r, err)
+	}
+}
+
+func movieCSVRecord(movie *data.Movie) []string {
+	certifications, _ := json.Marshal(nonNilMap(movie.Certifications))
+	externalIDs, _ := json.Marshal(nonNilMap(movie.ExternalIDs))
+	return []string{
+		strconv.FormatInt(movie.ID, 10),
+		movie.Title,
+		strconv.Itoa(int(movie.Year)),
+		strconv.Itoa(int(movie.Runtime)),
+		strings.Join(movie.Genres, csvListSeparator),
+		strconv.Itoa(int(movie.Version)),
+		movie.Synopsis,
+		movie.OriginalTitle,
+		movie.OriginalLanguage,
+		strings.Join(movie.SpokenLanguages, csvListSeparator),
+		string(certifications),
+		string(externalIDs),
+		movie.PosterURL,
+		movie.PosterThumbnailURL,
+	}
+}
+
+func nonNilMap(m map[string]string) map[string]string {
+	if m == nil {
+		return map[string]string{}
+	}
+	return m
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// project trims envelope[key] down to the listed JSON fields. The value can be a
+// struct or a slice of them, anything that encodes to an object or array of objects.
+// No fields leaves it alone
+func (e envelope) project(key string, fields []string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	js, err := json.Marshal(e[key])
+	if err != nil {
+		return err
+	}
+
+	js = bytes.TrimSpace(js)
+	if bytes.Equal(js, []byte("null")) {
+		return nil
+	}
+	//arrays get every element trimmed
+	if js[0] == '[' {
+		var items []json.RawMessage
+		err = json.Unmarshal(js, &items)
+		if err != nil {
+			return err
+		}
+		projected := make([]projectedObject, len(items))
+		for i, item := range items {
+			projected[i], err = projectObject(item, fields)
+			if err != nil {
+				return err
+			}
+		}
+		e[key] = projected
+		return nil
+	}
+
+	projected, err := projectObject(js, fields)
+	if err != nil {
+		return err
+	}
+	e[key] = projected
+	return nil
+}
+
+// projectedObject is a JSON object that keeps its keys in the original order
+// rather than the sorted order a map would get
+type projectedObject []projectedField
+
+type projectedField struct {
+	key   string
+	value json.RawMessage
+}
+
+func (o projectedObject) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, err := json.Marshal(f.key)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(f.value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// projectObject walks one JSON object keeping only the listed keys
+func projectObject(js []byte, fields []string) (projectedObject, error) {
+	dec := json.NewDecoder(bytes.NewReader(js))
+	//opening {
+	_, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	projected := projectedObject{}
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := token.(string)
+		var value json.RawMessage
+		err = dec.Decode(&value)
+		if err != nil {
+			return nil, err
+		}
+		if validator.In(key, fields...) {
+			projected = append(projected, projectedField{key: key, value: value})
+		}
+	}
+	return projected, nil
+}
+
+// readMovieFields reads ?fields= and ?include= for movie responses, problems go to v.
+// include falls back to defaultInclude when it isnt in the query string at all
+func (app *application) readMovieFields(qs url.Values, defaultInclude []string, v *validator.Validator) (fields, include []string) {
+	fields = app.readCSV(qs, "fields", nil)
+	include = defaultInclude
+	if qs.Has("include") {
+		include = app.readCSV(qs, "include", []string{})
+	}
+	data.ValidateMovieFields(v, fields, include)
+	return fields, include
+}
+
+// projectMovieFields is the JSON keys to keep for fields plus the embedded resources,
+// nil if the client wants every field
+func projectMovieFields(fields, include []string) []string {
+	if len(fields) == 0 {
+		return nil
+	}
+	return append(append([]string{}, fields...), include...)
+}
+
+// embedMovies loads the included related resources for a page of movies,
+// one query per resource however many movies there are
+func (app *application) embedMovies(movies []*data.Movie, include []string) error {
+	if len(movies) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.ID
+	}
+
+	for _, resource := range include {
+		switch resource {
+		case "release_dates":
+			byMovie, err := app.models.ReleaseDates.GetForMovies(ids)
+			if err != nil {
+				return err
+			}
+			for _, movie := range movies {
+				movie.ReleaseDates = byMovie[movie.ID]
+			}
+		case "series":
+			byMovie, err := app.models.Series.ForMovies(ids)
+			if err != nil {
+				return err
+			}
+			for _, movie := range movies {
+				movie.Series = byMovie[movie.ID]
+			}
+		case "credits":
+			byMovie, err := app.models.Credits.GetForMovies(ids)
+			if err != nil {
+				return err
+			}
+			for _, movie := range movies {
+				movie.Credits = byMovie[movie.ID]
+			}
+		case "rating":
+			byMovie, err := app.models.Ratings.SummaryForMovies(ids)
+			if err != nil {
+				return err
+			}
+			for _, movie := range movies {
+				movie.Rating = byMovie[movie.ID]
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"net/http"
+)
+
+// GET /v1/genres, lists the managed genres with how many movies use each one
+func (app *application) listGenresHandler(w http.ResponseWriter, r *http.Request) {
+	genres, err := app.models.Genres.GetAll()
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"genres": genres}, nil)
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+	}
+}
@@ -11,8 +11,10 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"greenlight.alexedwards.net/internal/jsonlog"
 	"greenlight.alexedwards.net/internal/validator"
 )
 
@@ -30,41 +32,24 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
-//WriteJson helper to send JSON reponses, takes destination http.responsewriter
-//http code to send, encodeds to JSON, alters header map if needed
-
-func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
-	//encode data to JSON, return error if error
-	//using no line prefix "" and tab indents \t for each element returned
-	js, err := json.MarshalIndent(data, "", "\t")
-	if err != nil {
-		return err
-	}
-	// append newline to make easy to read in terminal
-	js = append(js, '\n')
-
-	// include headers and map to http.responsewriter map
-	// Its ok if header is nul, no errors here
-	for key, value := range headers {
-		w.Header()[key] = value
-	}
-	// add application/json header and json response
-	w.Header().Set("Content-Type", "appilication/json")
-	w.WriteHeader(status)
-	w.Write(js)
-	return nil
-
-}
-
 // Helps decode JSON from request body as normal. Doing this to avoid
 // letting our public API give too much info away about how it works
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
 	maxBytes := 1_048_576 //limit size of req to 1mb
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	//MessagePack and friends come out of readBody as JSON, see requestDecoders
+	body, err := readBody(w, r, int64(maxBytes))
+	if err != nil {
+		return err
+	}
+	return decodeJSON(body, dst)
+}
 
+// decodeJSON is readJSON without the request, for JSON that comes from somewhere
+// else like a patched document. Same strictness and same error messages
+func decodeJSON(body io.Reader, dst interface{}) error {
 	//init decoder and do disallow unknown fields on it.
 	//Now if decoder gets a unknown field it will error instead of ignoring it
-	dec := json.NewDecoder(r.Body)
+	dec := json.NewDecoder(body)
 	dec.DisallowUnknownFields()
 
 	//decode reqest to target
@@ -161,12 +146,65 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// readOptionalInt is readInt for filters where missing means no filter, so it
+// returns nil instead of a default value
+func (app *application) readOptionalInt(qs url.Values, key string, v *validator.Validator) *int {
+	s := qs.Get(key)
+	if s == "" {
+		return nil
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		v.AddError(key, "must be a integer value")
+		return nil
+	}
+	return &i
+}
+
+// readTime reads a RFC 3339 timestamp or a plain 2006-01-02 date from the query string,
+// nil if its missing
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) *time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return nil
+	}
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return &t
+		}
+	}
+	v.AddError(key, "must be a RFC 3339 timestamp or YYYY-MM-DD date")
+	return nil
+}
+
+// readBool reads a true/false value from the query string, anything strconv.ParseBool
+// accepts is fine. Missing means default, bad values go to the validator
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+	return b
+}
+
 // help function to try  and wrap the recovering logic
 // uses Go's first class functions, where functions can be assigned to variables
 // and passed as parameters to other functions
-// background accepts any function wiht func() as param, stores it in fn var
-// its in the backgroun to try and recover any panic and logs err,
-func (app *application) background(fn func()) {
+// background accepts any function wiht func(logger) as param, stores it in fn var
+// its in the backgroun to try and recover any panic and logs err. requestID is the
+// request that started the job, "" if none, and goes on everything logger writes
+func (app *application) background(requestID string, fn func(logger *jsonlog.Logger)) {
+	logger := app.logger
+	if requestID != "" {
+		logger = logger.With("request_id", requestID)
+	}
+
 	// Increment the WaitGroup counter.
 	app.wg.Add(1)
 
@@ -177,10 +215,44 @@ func (app *application) background(fn func()) {
 
 		defer func() {
 			if err := recover(); err != nil {
-				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+				logger.PrintError(fmt.Errorf("%s", err), nil)
 			}
 		}()
 
-		fn()
+		fn(logger)
 	}()
 }
+
+// every runs fn straight away and then each interval in the background until the
+// server starts shutting down. A panic only loses that run, the next still happens
+func (app *application) every(interval time.Duration, fn func()) {
+	app.background("", func(logger *jsonlog.Logger) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			func() {
+				defer func() {
+					if err := recover(); err != nil {
+						logger.PrintError(fmt.Errorf("%s", err), nil)
+					}
+				}()
+				fn()
+			}()
+
+			select {
+			case <-ticker.C:
+			case <-app.shutdown:
+				return
+			}
+		}
+	})
+}
+
+// requestLogger is app.logger with the request ID on every entry
+func (app *application) requestLogger(r *http.Request) *jsonlog.Logger {
+	if id := app.contextGetRequestID(r); id != "" {
+		return app.logger.With("request_id", id)
+	}
+	return app.logger
+}
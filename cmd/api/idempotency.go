@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"greenlight.alexedwards.net/internal/data"
+)
+
+const (
+	maxIdempotencyKeyLength = 255
+	//the body is read up front to fingerprint it. Up to idempotentMemoryBytes stays in
+	//memory, past that it goes to a temp file, up to the import limit
+	idempotentMemoryBytes  = 1_048_576
+	maxIdempotentBodyBytes = maxImportBytes
+)
+
+// idempotencyRefused are routes whose responses hold credentials, so they must never
+// be stored and replayed
+var idempotencyRefused = map[string]bool{
+	"/v1/tokens/authentication": true,
+}
+
+var errIdempotentBodyTooLarge = errors.New("body is too large to send with an Idempotency-Key")
+
+// idempotency makes POSTs sent with an Idempotency-Key safe to retry. The first
+// request runs as normal and its response is stored, retries with the same key get
+// that response back without running the handler again. Keys are per user, anonymous
+// ones only match the same request, and expire after -idempotency-ttl. Server errors
+// arent stored so they can be retried. Error bodies that get stored leave out the
+// request ID, the X-Request-ID header carries it
+func (app *application) idempotency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if r.Method != http.MethodPost || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			app.badRequestResponse(w, r, errors.New("Idempotency-Key must not be more than 255 bytes long"))
+			return
+		}
+		if idempotencyRefused[r.URL.Path] {
+			app.badRequestResponse(w, r, errors.New("Idempotency-Key is not supported on this endpoint"))
+			return
+		}
+		//a body that gets spooled can be as big as an import, give it as long
+		if r.ContentLength < 0 || r.ContentLength > idempotentMemoryBytes {
+			err := http.NewResponseController(w).SetReadDeadline(time.Now().Add(importTimeout))
+			if err != nil {
+				app.serverErrorReponse(w, r, err)
+				return
+			}
+		}
+
+		hash, cleanup, err := spoolBody(r)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		defer cleanup()
+
+		//every anonymous caller is user 0, so their keys also carry the request
+		//fingerprint. Only a retry of the exact same request gets a stored
+		//response back, another caller reusing the key just gets their own
+		user := app.contextGetUser(r)
+		if user.IsAnonymous() {
+			key += ":" + hex.EncodeToString(hash)
+		}
+
+		record := &data.IdempotencyKey{
+			UserID:      user.ID,
+			Key:         key,
+			RequestHash: hash,
+			ExpiresAt:   time.Now().Add(app.config.idempotency.ttl),
+		}
+		reserved, err := app.models.Idempotency.Reserve(record)
+		if err != nil {
+			app.serverErrorReponse(w, r, err)
+			return
+		}
+		if !reserved {
+			app.replayIdempotent(w, r, record)
+			return
+		}
+
+		//from here the key is ours, it gets released unless the response is stored,
+		//panics included so a crashed request can be retried
+		stored := false
+		defer func() {
+			if !stored {
+				err := app.models.Idempotency.Release(record.UserID, record.Key)
+				if err != nil {
+					app.logError(r, err)
+				}
+			}
+		}()
+
+		//headers already set by outer middleware get set again on a replay, only keep the handlers
+		before := make(map[string]bool)
+		for name := range w.Header() {
+			before[name] = true
+		}
+
+		//the headers are copied as the handler sends them, before compress sees them.
+		//The body we keep is the uncompressed one, so Content-Encoding and the
+		//coding tagged ETag compress adds after that cant be stored with it
+		captured := &idempotentResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(captured, app.contextSetReplayable(r))
+
+		if !idempotentStored(captured.status) {
+			return
+		}
+		record.StatusCode = captured.status
+		record.Body = captured.body.Bytes()
+		record.Headers = make(map[string][]string)
+		for name, values := range captured.headers {
+			if !before[name] {
+				record.Headers[name] = values
+			}
+		}
+		err = app.models.Idempotency.Complete(record)
+		if err != nil {
+			//the client already has its response, a retry will just run again
+			app.logError(r, err)
+			return
+		}
+		stored = true
+	})
+}
+
+// idempotentResponseWriter keeps a copy of the response for storing
+type idempotentResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	headers http.Header //as they were when the handler sent them
+	body    bytes.Buffer
+}
+
+func (iw *idempotentResponseWriter) WriteHeader(status int) {
+	if iw.status == 0 {
+		iw.status = status
+		iw.headers = iw.Header().Clone()
+	}
+	iw.ResponseWriter.WriteHeader(status)
+}
+
+func (iw *idempotentResponseWriter) Write(b []byte) (int, error) {
+	if iw.status == 0 {
+		iw.status = http.StatusOK
+		iw.headers = iw.Header().Clone()
+	}
+	iw.body.Write(b)
+	return iw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the real writer
+func (iw *idempotentResponseWriter) Unwrap() http.ResponseWriter {
+	return iw.ResponseWriter
+}
+
+// idempotentStored is whether a response with status is kept for replays. Server
+// errors and rate limiting arent, the client should try again for real
+func idempotentStored(status int) bool {
+	return status != 0 && status < 500 && status != http.StatusTooManyRequests
+}
+
+// replayIdempotent answers a request whose key was already used
+func (app *application) replayIdempotent(w http.ResponseWriter, r *http.Request, record *data.IdempotencyKey) {
+	existing, err := app.models.Idempotency.Get(record.UserID, record.Key)
+	if err != nil {
+		switch {
+		//released between our Reserve and Get, the other request failed
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.errorResponse(w, r, http.StatusConflict, "a request with this Idempotency-Key just failed, retry it")
+		default:
+			app.serverErrorReponse(w, r, err)
+		}
+		return
+	}
+
+	switch {
+	case !bytes.Equal(existing.RequestHash, record.RequestHash):
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, "this Idempotency-Key was already used for a different request")
+	case existing.StatusCode == 0:
+		app.errorResponse(w, r, http.StatusConflict, "a request with this Idempotency-Key is still being processed")
+	default:
+		for name, values := range existing.Headers {
+			w.Header()[name] = values
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(existing.StatusCode)
+		w.Write(existing.Body)
+	}
+}
+
+// spoolBody reads the whole body so the handler can have it again, and returns a
+// fingerprint of what the key was used for. Small bodies are kept in memory, bigger
+// ones like imports are copied to a temp file that cleanup removes
+func spoolBody(r *http.Request) ([]byte, func(), error) {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+
+	var buf bytes.Buffer
+	n, err := io.Copy(io.MultiWriter(h, &buf), io.LimitReader(r.Body, idempotentMemoryBytes+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {}
+
+	if n > idempotentMemoryBytes {
+		f, err := os.CreateTemp("", "greenlight-idempotency-*")
+		if err != nil {
+			return nil, nil, err
+		}
+		cleanup = func() {
+			f.Close()
+			os.Remove(f.Name())
+		}
+		_, err = f.Write(buf.Bytes())
+		if err == nil {
+			var more int64
+			more, err = io.Copy(io.MultiWriter(h, f), io.LimitReader(r.Body, maxIdempotentBodyBytes+1-n))
+			n += more
+		}
+		if err == nil && n > maxIdempotentBodyBytes {
+			err = errIdempotentBodyTooLarge
+		}
+		if err == nil {
+			_, err = f.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		r.Body = f
+	} else {
+		r.Body = io.NopCloser(&buf)
+	}
+
+	io.WriteString(h, "\n"+strconv.FormatInt(n, 10))
+	return h.Sum(nil), cleanup, nil
+}
+
+// deleteExpiredIdempotencyKeys is run by every() from main
+func (app *application) deleteExpiredIdempotencyKeys() {
+	deleted, err := app.models.Idempotency.DeleteExpired()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+	if deleted > 0 {
+		app.logger.PrintInfo("deleted expired idempotency keys", map[string]string{"count": strconv.FormatInt(deleted, 10)})
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/jsonlog"
+)
+
+// idempotencyDB stands in for postgres, it only knows the queries IdempotencyModel
+// runs against idempotency_keys
+type idempotencyDB struct {
+	mu   sync.Mutex
+	rows map[string]*data.IdempotencyKey
+}
+
+func (db *idempotencyDB) Open(string) (driver.Conn, error) { return idempotencyConn{db}, nil }
+
+type idempotencyConn struct{ db *idempotencyDB }
+
+func (c idempotencyConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepare not supported")
+}
+func (c idempotencyConn) Close() error              { return nil }
+func (c idempotencyConn) Begin() (driver.Tx, error) { return nil, errors.New("begin not supported") }
+
+func rowKey(args []driver.NamedValue) string {
+	return fmt.Sprint(args[0].Value, "/", args[1].Value)
+}
+
+func (c idempotencyConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	db := c.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	query = strings.Join(strings.Fields(query), " ")
+	switch {
+	case strings.HasPrefix(query, "DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2 AND expires_at"):
+		if k, ok := db.rows[rowKey(args)]; ok && k.ExpiresAt.Before(time.Now()) {
+			delete(db.rows, rowKey(args))
+		}
+		return driver.RowsAffected(0), nil
+	case strings.HasPrefix(query, "DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2"):
+		delete(db.rows, rowKey(args))
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(query, "INSERT INTO idempotency_keys"):
+		if _, ok := db.rows[rowKey(args)]; ok {
+			return driver.RowsAffected(0), nil
+		}
+		db.rows[rowKey(args)] = &data.IdempotencyKey{
+			RequestHash: args[2].Value.([]byte),
+			ExpiresAt:   args[3].Value.(time.Time),
+		}
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(query, "UPDATE idempotency_keys"):
+		k := db.rows[rowKey(args[3:])]
+		k.StatusCode = int(args[0].Value.(int64))
+		if err := json.Unmarshal(args[1].Value.([]byte), &k.Headers); err != nil {
+			return nil, err
+		}
+		k.Body = args[2].Value.([]byte)
+		return driver.RowsAffected(1), nil
+	}
+	return nil, fmt.Errorf("unexpected query %q", query)
+}
+
+func (c idempotencyConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	db := c.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	k, ok := db.rows[rowKey(args)]
+	if !ok {
+		return &idempotencyRows{}, nil
+	}
+	headers, err := json.Marshal(k.Headers)
+	if err != nil {
+		return nil, err
+	}
+	return &idempotencyRows{row: []driver.Value{k.RequestHash, int64(k.StatusCode), headers, k.Body, k.ExpiresAt}}, nil
+}
+
+type idempotencyRows struct {
+	row  []driver.Value
+	done bool
+}
+
+func (r *idempotencyRows) Columns() []string {
+	return []string{"request_hash", "status_code", "response_headers", "response_body", "expires_at"}
+}
+func (r *idempotencyRows) Close() error { return nil }
+func (r *idempotencyRows) Next(dest []driver.Value) error {
+	if r.row == nil || r.done {
+		return io.EOF
+	}
+	copy(dest, r.row)
+	r.done = true
+	return nil
+}
+
+var registerIdempotencyDB sync.Once
+
+func newIdempotencyTestApp(t *testing.T) *application {
+	t.Helper()
+	registerIdempotencyDB.Do(func() {
+		sql.Register("idempotencytest", &idempotencyDB{rows: make(map[string]*data.IdempotencyKey)})
+	})
+	db, err := sql.Open("idempotencytest", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	app := &application{
+		logger: jsonlog.New(io.Discard, jsonlog.LevelInfo),
+		models: data.NewModels(db),
+	}
+	app.config.idempotency.ttl = time.Hour
+	app.config.compression.enabled = true
+	app.config.compression.minSize = 1024
+	return app
+}
+
+// a replay has to be compressed for the client that asks for it, not labelled
+// compressed because the first response was
+func TestIdempotencyReplayCompressed(t *testing.T) {
+	app := newIdempotencyTestApp(t)
+
+	body := `{"movie":{"title":"` + strings.Repeat("Casablanca ", 200) + `"}}`
+	runs := 0
+	handler := app.compress(app.idempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		runs++
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", `"1"`)
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, body)
+	})))
+
+	send := func(acceptEncoding string) *http.Response {
+		r := httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(`{"title":"Casablanca"}`))
+		r.Header.Set("Idempotency-Key", "replay-compressed")
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+		r = app.contextSetUser(r, &data.User{ID: 1})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, r)
+		return rr.Result()
+	}
+	read := func(res *http.Response) string {
+		t.Helper()
+		var rd io.Reader = res.Body
+		if res.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(res.Body)
+			if err != nil {
+				t.Fatalf("body labelled gzip isnt: %v", err)
+			}
+			rd = zr
+		}
+		b, err := io.ReadAll(rd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		encoding       string
+		etag           string
+		replayed       string
+	}{
+		{"first", "gzip", "gzip", `"1-gzip"`, ""},
+		{"replay gzip", "gzip", "gzip", `"1-gzip"`, "true"},
+		{"replay plain", "identity", "", `"1"`, "true"},
+	}
+	for _, tt := range tests {
+		res := send(tt.acceptEncoding)
+		if res.StatusCode != http.StatusCreated {
+			t.Errorf("%s: status %d, want %d", tt.name, res.StatusCode, http.StatusCreated)
+		}
+		if got := res.Header.Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("%s: Content-Encoding %q, want %q", tt.name, got, tt.encoding)
+		}
+		if got := res.Header.Get("ETag"); got != tt.etag {
+			t.Errorf("%s: ETag %s, want %s", tt.name, got, tt.etag)
+		}
+		if got := res.Header.Get("Idempotent-Replayed"); got != tt.replayed {
+			t.Errorf("%s: Idempotent-Replayed %q, want %q", tt.name, got, tt.replayed)
+		}
+		if got := res.Header.Values("Vary"); len(got) != 1 {
+			t.Errorf("%s: Vary %q, want it once", tt.name, got)
+		}
+		if got := read(res); got != body {
+			t.Errorf("%s: body came back as %d bytes, want %d", tt.name, len(got), len(body))
+		}
+	}
+	if runs != 1 {
+		t.Errorf("handler ran %d times, want 1", runs)
+	}
+}
+
+// anonymous callers all share user 0, a key only gets them a response stored for
+// the same request
+func TestIdempotencyAnonymous(t *testing.T) {
+	app := newIdempotencyTestApp(t)
+
+	runs := 0
+	handler := app.idempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		runs++
+		w.WriteHeader(http.StatusCreated)
+		io.Copy(w, r.Body)
+	}))
+
+	tests := []struct {
+		name     string
+		path     string
+		body     string
+		status   int
+		replayed string
+		runs     int
+	}{
+		{"sign up", "/v1/users", `{"email":"a@example.com"}`, http.StatusCreated, "", 1},
+		{"retry", "/v1/users", `{"email":"a@example.com"}`, http.StatusCreated, "true", 1},
+		{"someone else", "/v1/users", `{"email":"b@example.com"}`, http.StatusCreated, "", 2},
+		{"credentials", "/v1/tokens/authentication", `{"email":"a@example.com"}`, http.StatusBadRequest, "", 2},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+		r.Header.Set("Idempotency-Key", "anonymous")
+		r = app.contextSetUser(r, data.AnonymousUser)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, r)
+
+		if rr.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, rr.Code, tt.status)
+		}
+		if got := rr.Header().Get("Idempotent-Replayed"); got != tt.replayed {
+			t.Errorf("%s: Idempotent-Replayed %q, want %q", tt.name, got, tt.replayed)
+		}
+		if tt.status == http.StatusCreated && rr.Body.String() != tt.body {
+			t.Errorf("%s: body %q, want %q", tt.name, rr.Body.String(), tt.body)
+		}
+		if runs != tt.runs {
+			t.Errorf("%s: handler has run %d times, want %d", tt.name, runs, tt.runs)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+const (
+	maxImportBytes  = 100 << 20 //100mb
+	maxImportErrors = 100       //row errors listed in the response, the count is always right
+	//how long the body can take to arrive, instead of the servers ReadTimeout
+	importTimeout = 10 * time.Minute
+)
+
+// importRowError is what went wrong with one line of an import
+type importRowError struct {
+	Line   int               `json:"line"`
+	Errors map[string]string `json:"errors"`
+}
+
+// importFileError is a problem with the file as a whole, like a bad CSV header
+type importFileError struct {
+	message string
+}
+
+func (e *importFileError) Error() string {
+	return e.message
+}
+
+// importRow gets each parsed line, errs holds parse problems if there were any
+type importRow func(line int, input createMovieInput, errs map[string]string) error
+
+// POST /v1/movies/import?format=csv|ndjson, takes the same files export gives out.
+// Any invalid row means nothing is imported unless skip_invalid=true
+func (app *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	defaultFormat := formatNDJSON
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+		defaultFormat = formatCSV
+	}
+	format := app.readString(qs, "format", defaultFormat)
+	skipInvalid := app.readBool(qs, "skip_invalid", false, v)
+
+	v.Check(validator.In(format, formatCSV, formatNDJSON), "format", "must be csv or ndjson")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	knownGenres, err := app.models.Genres.Slugs()
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+		return
+	}
+
+	imp, err := app.movies(r).BeginImport()
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+		return
+	}
+	defer imp.Rollback()
+
+	rowErrors := []importRowError{}
+	failed := 0
+	add := func(line int, input createMovieInput, errs map[string]string) error {
+		movie := input.movie()
+		if errs == nil {
+			v := validator.New()
+			data.ValidateMovie(v, movie, knownGenres)
+			errs = v.Errors
+		}
+		if len(errs) > 0 {
+			failed++
+			if len(rowErrors) < maxImportErrors {
+				rowErrors = append(rowErrors, importRowError{Line: line, Errors: errs})
+			}
+			return nil
+		}
+		return imp.Add(movie)
+	}
+
+	//100mb wont get here inside the servers ReadTimeout on a slow connection
+	err = http.NewResponseController(w).SetReadDeadline(time.Now().Add(importTimeout))
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	switch format {
+	case formatCSV:
+		err = readCSVImport(r.Body, add)
+	default:
+		err = readNDJSONImport(r.Body, add)
+	}
+	if err != nil {
+		var fileErr *importFileError
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &fileErr), errors.As(err, &maxBytesErr):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.serverErrorReponse(w, r, err)
+		}
+		return
+	}
+
+	if failed > 0 && !skipInvalid {
+		err = app.writeResponse(w, r, http.StatusUnprocessableEntity, envelope{
+			"error":  "import has invalid rows, nothing was imported",
+			"failed": failed,
+			"errors": rowErrors,
+		}, nil)
+		if err != nil {
+			app.serverErrorReponse(w, r, err)
+		}
+		return
+	}
+
+	imported, err := imp.Commit()
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"imported": imported, "failed": failed, "errors": rowErrors}, nil)
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+	}
+}
+
+// readCSVImport reads a CSV with a header row naming movieCSVColumns, in any order.
+// id, version and the poster columns are ignored so exports can go straight back in
+func readCSVImport(body io.Reader, add importRow) error {
+	reader := csv.NewReader(body)
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return &importFileError{"body must not be empty"}
+		}
+		return csvImportError(err)
+	}
+	seen := map[string]bool{}
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if !validator.In(name, movieCSVColumns...) {
+			return &importFileError{fmt.Sprintf("unknown column %q", name)}
+		}
+		if seen[name] {
+			return &importFileError{fmt.Sprintf("duplicate column %q", name)}
+		}
+		seen[name] = true
+		header[i] = name
+	}
+	if !seen["title"] {
+		return &importFileError{"missing title column"}
+	}
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return csvImportError(err)
+		}
+		line, _ := reader.FieldPos(0)
+
+		input, errs := parseCSVMovie(header, record)
+		err = add(line, input, errs)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// broken CSV cant be read past, so it fails the whole file
+func csvImportError(err error) error {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return &importFileError{parseErr.Error()}
+	}
+	return err
+}
+
+// parseCSVMovie turns one record into the same input a POST takes, errs is nil
+// if every field parsed
+func parseCSVMovie(header, record []string) (createMovieInput, map[string]string) {
+	var input createMovieInput
+	errs := map[string]string{}
+
+	for i, value := range record {
+		switch header[i] {
+		case "title":
+			input.Title = value
+		case "year":
+			if value != "" {
+				year, err := strconv.ParseInt(value, 10, 32)
+				if err != nil {
+					errs["year"] = "must be an integer"
+				}
+				input.Year = int32(year)
+			}
+		case "runtime":
+			if value != "" {
+				//plain minutes, or "102 mins" like the JSON
+				runtime, err := strconv.ParseInt(strings.TrimSuffix(value, " mins"), 10, 32)
+				if err != nil {
+					errs["runtime"] = "must be an integer number of minutes"
+				}
+				input.Runtime = data.Runtime(runtime)
+			}
+		case "genres":
+			input.Genres = splitCSVList(value)
+		case "synopsis":
+			input.Synopsis = value
+		case "original_title":
+			input.OriginalTitle = value
+		case "original_language":
+			input.OriginalLanguage = value
+		case "spoken_languages":
+			input.SpokenLanguages = splitCSVList(value)
+		case "certifications":
+			if value != "" && json.Unmarshal([]byte(value), &input.Certifications) != nil {
+				errs["certifications"] = "must be a JSON object of strings"
+			}
+		case "external_ids":
+			if value != "" && json.Unmarshal([]byte(value), &input.ExternalIDs) != nil {
+				errs["external_ids"] = "must be a JSON object of strings"
+			}
+		}
+	}
+
+	if len(errs) == 0 {
+		return input, nil
+	}
+	return input, errs
+}
+
+func splitCSVList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, csvListSeparator)
+}
+
+// readNDJSONImport reads one movie JSON object per line, as POST /v1/movies takes
+// or as export writes them
+func readNDJSONImport(body io.Reader, add importRow) error {
+	reader := bufio.NewReader(body)
+
+	for line := 1; ; line++ {
+		b, readErr := reader.ReadBytes('\n')
+		if readErr != nil && !errors.Is(readErr, io.EOF) {
+			return readErr
+		}
+
+		if b = bytes.TrimSpace(b); len(b) > 0 {
+			//exports carry these too, they are ignored
+			var row struct {
+				createMovieInput
+				ID                 int64           `json:"id"`
+				Version            int32           `json:"version"`
+				PosterURL          string          `json:"poster_url"`
+				PosterThumbnailURL string          `json:"poster_thumbnail_url"`
+				ReleaseDates       json.RawMessage `json:"release_dates"`
+				Series             json.RawMessage `json:"series"`
+			}
+			dec := json.NewDecoder(bytes.NewReader(b))
+			dec.DisallowUnknownFields()
+
+			var errs map[string]string
+			err := dec.Decode(&row)
+			if err == nil && dec.More() {
+				err = errors.New("line must contain a single JSON object")
+			}
+			if err != nil {
+				errs = map[string]string{"json": err.Error()}
+			}
+
+			err = add(line, row.createMovieInput, errs)
+			if err != nil {
+				return err
+			}
+		}
+
+		if errors.Is(readErr, io.EOF) {
+			return nil
+		}
+	}
+}
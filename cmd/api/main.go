@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
@@ -13,9 +15,11 @@ import (
 	"time"
 
 	_ "github.com/lib/pq"
+	"greenlight.alexedwards.net/internal/blobstore"
 	"greenlight.alexedwards.net/internal/data"
 	"greenlight.alexedwards.net/internal/jsonlog"
 	"greenlight.alexedwards.net/internal/mailer"
+	"greenlight.alexedwards.net/internal/similar"
 )
 
 var (
@@ -52,16 +56,51 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	//key for signing pagination cursors
+	cursor struct {
+		secret []byte
+	}
+	//postgres text search config for /v1/search, decides stemming and stop words
+	search struct {
+		language string
+	}
+	//how often the in-memory similar movies model is rebuilt
+	similar struct {
+		refresh time.Duration
+	}
+	//how long Idempotency-Key responses are kept for replays
+	idempotency struct {
+		ttl time.Duration
+	}
+	//how long deleted movies stay restorable
+	trash struct {
+		retention time.Duration
+	}
+	//zstd/gzip/deflate for responses, bodies under minSize bytes arent worth it
+	compression struct {
+		enabled bool
+		minSize int
+	}
+	//where uploaded files like posters go, local disk or anything S3 compatible
+	blob struct {
+		store    string
+		localDir string
+		localURL string
+		s3       blobstore.S3Config
+	}
 }
 
 // app struct to hold HTTP depends, helpers, and middleware.
 // Note the custom logger call here
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup //Used to allow very graceful shutdown with sync.waitgroups
+	config   config
+	logger   *jsonlog.Logger
+	models   data.Models
+	mailer   mailer.Mailer
+	blobs    blobstore.BlobStore
+	similar  *similar.Model
+	shutdown chan struct{}  //closed when the server starts shutting down, stops the every() jobs
+	wg       sync.WaitGroup //Used to allow very graceful shutdown with sync.waitgroups
 }
 
 func main() {
@@ -99,6 +138,27 @@ func main() {
 		cfg.cors.trustedOrigins = strings.Fields(val)
 		return nil
 	})
+	//blob storage for uploads, local by default. s3 works with AWS or a local minio
+	flag.StringVar(&cfg.blob.store, "blob-store", "local", "Blob store for uploads (local|s3)")
+	flag.StringVar(&cfg.blob.localDir, "blob-local-dir", "./uploads", "Directory for the local blob store")
+	flag.StringVar(&cfg.blob.localURL, "blob-local-url", "http://localhost:4000/uploads", "Public URL the local blob store is served from")
+	flag.StringVar(&cfg.blob.s3.Endpoint, "s3-endpoint", "", "S3 endpoint, like https://s3.us-east-1.amazonaws.com or http://localhost:9000")
+	flag.StringVar(&cfg.blob.s3.Region, "s3-region", "us-east-1", "S3 region")
+	flag.StringVar(&cfg.blob.s3.Bucket, "s3-bucket", "", "S3 bucket")
+	flag.StringVar(&cfg.blob.s3.AccessKey, "s3-access-key", "", "S3 access key")
+	flag.StringVar(&cfg.blob.s3.SecretKey, "s3-secret-key", "", "S3 secret key")
+	flag.StringVar(&cfg.blob.s3.PublicURL, "s3-public-url", "", "Public URL for the bucket (defaults to endpoint/bucket)")
+
+	flag.StringVar(&cfg.search.language, "search-language", "english", "PostgreSQL text search config for search (english|simple|french|...)")
+
+	flag.DurationVar(&cfg.similar.refresh, "similar-refresh", 10*time.Minute, "How often to rebuild the similar movies model")
+	flag.DurationVar(&cfg.idempotency.ttl, "idempotency-ttl", 24*time.Hour, "How long Idempotency-Key responses are kept")
+	flag.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "How long deleted movies can be restored before they are purged")
+	flag.BoolVar(&cfg.compression.enabled, "compression-enabled", true, "Compress responses when the client accepts it")
+	flag.IntVar(&cfg.compression.minSize, "compression-min-size", 1024, "Smallest response body in bytes worth compressing")
+
+	cursorSecret := flag.String("cursor-secret", "", "Secret for signing pagination cursors (random per process if empty)")
+
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 	flag.Parse()
 
@@ -112,6 +172,29 @@ func main() {
 	//init cust logger for any err at or above INFO to outscreen
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
+	//these drive tickers and expiry times, 0 or less would panic every() or purge
+	//things straight away
+	for name, d := range map[string]time.Duration{
+		"similar-refresh": cfg.similar.refresh,
+		"idempotency-ttl": cfg.idempotency.ttl,
+		"trash-retention": cfg.trash.retention,
+	} {
+		if d <= 0 {
+			logger.PrintFatal(fmt.Errorf("-%s must be greater than zero", name), nil)
+		}
+	}
+
+	//without a configured secret cursors still work, but stop being valid on restart
+	cfg.cursor.secret = []byte(*cursorSecret)
+	if len(cfg.cursor.secret) == 0 {
+		cfg.cursor.secret = make([]byte, 32)
+		_, err := rand.Read(cfg.cursor.secret)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		logger.PrintInfo("no -cursor-secret set, using a random one", nil)
+	}
+
 	//call openDB function to create connection pool
 	//pass in config struct, if err we log it and exit immediately
 	db, err := openDB(cfg)
@@ -124,6 +207,11 @@ func main() {
 
 	//log nessage saying pool was success
 	logger.PrintInfo("database connection pool established", nil)
+
+	blobs, err := openBlobStore(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
 	//create new 'version' var in expvar var const above to increment
 	expvar.NewString("version").Set(version)
 	//publish # of goroutines
@@ -140,12 +228,25 @@ func main() {
 	}))
 	//declare logger struct from app struct
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:   cfg,
+		logger:   logger,
+		models:   data.NewModels(db),
+		mailer:   mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		blobs:    blobs,
+		similar:  similar.New(),
+		shutdown: make(chan struct{}),
 	} //Mailer instance into application struct
 
+	//rebuilds the search vectors if the language changed since last run
+	err = app.models.Search.SetLanguage(cfg.search.language)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	app.every(cfg.similar.refresh, app.refreshSimilar)
+	app.every(time.Hour, app.deleteExpiredIdempotencyKeys)
+	app.every(time.Hour, app.purgeTrash)
+
 	//create http server with timeouts, using port provided - moved to server.go
 	err = app.serve()
 	if err != nil {
@@ -189,3 +290,18 @@ func openDB(cfg config) (*sql.DB, error) {
 	//return db conn pool
 	return db, nil
 }
+
+// picks the blob store from the -blob-store flag
+func openBlobStore(cfg config) (blobstore.BlobStore, error) {
+	switch cfg.blob.store {
+	case "local":
+		return blobstore.NewLocal(cfg.blob.localDir, cfg.blob.localURL)
+	case "s3":
+		if cfg.blob.s3.Endpoint == "" || cfg.blob.s3.Bucket == "" {
+			return nil, errors.New("-s3-endpoint and -s3-bucket are required for the s3 blob store")
+		}
+		return blobstore.NewS3(cfg.blob.s3), nil
+	default:
+		return nil, fmt.Errorf("unknown blob store %q", cfg.blob.store)
+	}
+}
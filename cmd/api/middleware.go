@@ -4,6 +4,8 @@ package main
 //and log a err message and stack trace
 //with below we want to do the same, but send a 500 err
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"expvar"
 	"fmt"
@@ -13,7 +15,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/felixge/httpsnoop"
 	"github.com/tomasen/realip"
 	"golang.org/x/time/rate"
 	"greenlight.alexedwards.net/internal/data"
@@ -201,6 +202,11 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 		// Check if the slice includes the required permission. If it doesn't, then
 		// return a 403 Forbidden response.
 		if !permissions.Include(code) {
+			app.audit(r, data.AuditPermissionDenied, user.ID, map[string]string{
+				"permission": code,
+				"method":     r.Method,
+				"path":       r.URL.Path,
+			})
 			app.notPermittedResponse(w, r)
 			return
 		}
@@ -229,12 +235,14 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 				if origin == app.config.cors.trustedOrigins[i] {
 					//if match, set response header with req orgin as value
 					w.Header().Set("Access-Control-Allow-Origin", origin)
+					//so browser clients can read the ETag for If-Match
+					w.Header().Set("Access-Control-Expose-Headers", "ETag, X-Request-ID")
 					//chk if req has HTTP method options and has request-method header
 					//if so, treat as preflight request
 					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 						//set preflight headers
 						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Methods", "Authorization, Content-Type")
+						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match, If-None-Match, Idempotency-Key, X-Request-ID")
 						//write headers with 200 OK status and return frm Middleware with no action
 						w.WriteHeader(http.StatusOK)
 						return
@@ -263,15 +271,84 @@ func (app *application) metrics(next http.Handler) http.Handler {
 
 		//use add to + num of reqs by 1
 		totalRequestsReceived.Add(1)
-		metrics := httpsnoop.CaptureMetrics(next, w, r)
+		start := time.Now()
+		mw := &metricsResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(mw, r)
 
 		//on way back up, +
 		totalResponsesSent.Add(1)
 		//calc microseconds since we began
 
-		totalProcessingTimeMicroseconds.Add(metrics.Duration.Microseconds())
+		totalProcessingTimeMicroseconds.Add(time.Since(start).Microseconds())
 		//add method to + count for status code
-		totalResponsesSentByStatus.Add(strconv.Itoa(metrics.Code), 1)
+		totalResponsesSentByStatus.Add(strconv.Itoa(mw.statusCode), 1)
 
 	})
 }
+
+// metricsResponseWriter notes the status code for metrics. httpsnoop did this before,
+// but its wrapper has no Unwrap so http.ResponseController couldnt get past it to
+// flush or move deadlines
+type metricsResponseWriter struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+}
+
+func (mw *metricsResponseWriter) WriteHeader(statusCode int) {
+	if !mw.wroteHeader {
+		mw.statusCode = statusCode
+		mw.wroteHeader = true
+	}
+	mw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (mw *metricsResponseWriter) Write(b []byte) (int, error) {
+	mw.wroteHeader = true
+	return mw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the real writer
+func (mw *metricsResponseWriter) Unwrap() http.ResponseWriter {
+	return mw.ResponseWriter
+}
+
+// a request id from the client or a proxy is kept if it looks sane, otherwise we make
+// our own. It goes in the context for logs, error bodies and background jobs
+const maxRequestIDLength = 128
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("-_.:", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// requestID gives every request an X-Request-ID and echoes it on the response. It
+// sits outside compress and recoverPanic so even panics get one
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			b := make([]byte, 16)
+			_, err := rand.Read(b)
+			if err != nil {
+				app.serverErrorReponse(w, r, err)
+				return
+			}
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		r = app.contextSetRequestID(r, id)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -1,24 +1,235 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
+	"strings"
 
+	"github.com/julienschmidt/httprouter"
 	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/jsonpatch"
 	"greenlight.alexedwards.net/internal/validator"
 )
 
+// createMovieInput is the body for creating a movie, also used by the batch endpoint
+type createMovieInput struct {
+	Title            string              `json:"title"`
+	Year             int32               `json:"year"`
+	Runtime          data.Runtime        `json:"runtime"`
+	Genres           []string            `json:"genres"`
+	Synopsis         string              `json:"synopsis"`
+	OriginalTitle    string              `json:"original_title"`
+	OriginalLanguage string              `json:"original_language"`
+	SpokenLanguages  []string            `json:"spoken_languages"`
+	Certifications   data.Certifications `json:"certifications"`
+	ExternalIDs      data.ExternalIDs    `json:"external_ids"`
+}
+
+func (input createMovieInput) movie() *data.Movie {
+	return &data.Movie{
+		Title:   input.Title,
+		Year:    input.Year,
+		Runtime: input.Runtime,
+		Genres:  data.NormalizeGenres(input.Genres),
+
+		Synopsis:         input.Synopsis,
+		OriginalTitle:    input.OriginalTitle,
+		OriginalLanguage: input.OriginalLanguage,
+		SpokenLanguages:  input.SpokenLanguages,
+		Certifications:   input.Certifications,
+		ExternalIDs:      input.ExternalIDs,
+	}
+}
+
+// updateMovieInput is the body for a partial update.
+// added pointers to enable partial updates as before we did not allow nil in fields
+type updateMovieInput struct {
+	Title            *string             `json:"title"`
+	Year             *int32              `json:"year"`
+	Runtime          *data.Runtime       `json:"runtime"`
+	Genres           []string            `json:"genres"`
+	Synopsis         *string             `json:"synopsis"`
+	OriginalTitle    *string             `json:"original_title"`
+	OriginalLanguage *string             `json:"original_language"`
+	SpokenLanguages  []string            `json:"spoken_languages"`
+	Certifications   data.Certifications `json:"certifications"`
+	ExternalIDs      data.ExternalIDs    `json:"external_ids"`
+}
+
+// apply copies the fields that were sent onto movie
+func (input updateMovieInput) apply(movie *data.Movie) {
+	//if input.title is nil then we know no title keyword provided by req
+	//as .title is now a pointer to a string, we can use the * operator to get the value
+	if input.Title != nil {
+		movie.Title = *input.Title
+	}
+	//do same for other fields in the input struct
+	if input.Year != nil {
+		movie.Year = *input.Year
+	}
+	if input.Runtime != nil {
+		movie.Runtime = *input.Runtime
+	}
+	if input.Genres != nil {
+		movie.Genres = data.NormalizeGenres(input.Genres)
+	}
+	if input.Synopsis != nil {
+		movie.Synopsis = *input.Synopsis
+	}
+	if input.OriginalTitle != nil {
+		movie.OriginalTitle = *input.OriginalTitle
+	}
+	if input.OriginalLanguage != nil {
+		movie.OriginalLanguage = *input.OriginalLanguage
+	}
+	//slices and maps are nil when absent, sending [] or {} clears them
+	if input.SpokenLanguages != nil {
+		movie.SpokenLanguages = input.SpokenLanguages
+	}
+	if input.Certifications != nil {
+		movie.Certifications = input.Certifications
+	}
+	if input.ExternalIDs != nil {
+		movie.ExternalIDs = input.ExternalIDs
+	}
+}
+
+// PATCH bodies besides plain JSON, picked by Content-Type
+const (
+	mergePatchContentType = "application/merge-patch+json" //RFC 7396
+	jsonPatchContentType  = "application/json-patch+json"  //RFC 6902
+)
+
+// movieDocument is the editable part of a movie as a JSON document, merge patches
+// and JSON patches get applied to it. No omitempty so every field is there to
+// replace or test, and empty lists are [] so "add /genres/-" works
+type movieDocument struct {
+	Title            string              `json:"title"`
+	Year             int32               `json:"year"`
+	Runtime          data.Runtime        `json:"runtime"`
+	Genres           []string            `json:"genres"`
+	Synopsis         string              `json:"synopsis"`
+	OriginalTitle    string              `json:"original_title"`
+	OriginalLanguage string              `json:"original_language"`
+	SpokenLanguages  []string            `json:"spoken_languages"`
+	Certifications   data.Certifications `json:"certifications"`
+	ExternalIDs      data.ExternalIDs    `json:"external_ids"`
+}
+
+func newMovieDocument(movie *data.Movie) movieDocument {
+	doc := movieDocument{
+		Title:            movie.Title,
+		Year:             movie.Year,
+		Runtime:          movie.Runtime,
+		Genres:           movie.Genres,
+		Synopsis:         movie.Synopsis,
+		OriginalTitle:    movie.OriginalTitle,
+		OriginalLanguage: movie.OriginalLanguage,
+		SpokenLanguages:  movie.SpokenLanguages,
+		Certifications:   movie.Certifications,
+		ExternalIDs:      movie.ExternalIDs,
+	}
+	if doc.Genres == nil {
+		doc.Genres = []string{}
+	}
+	if doc.SpokenLanguages == nil {
+		doc.SpokenLanguages = []string{}
+	}
+	if doc.Certifications == nil {
+		doc.Certifications = data.Certifications{}
+	}
+	if doc.ExternalIDs == nil {
+		doc.ExternalIDs = data.ExternalIDs{}
+	}
+	return doc
+}
+
+// apply copies the whole document onto movie, a field the patch removed comes out
+// empty and ValidateMovie decides if thats allowed. Removing spoken_languages or
+// setting it to null leaves an empty list, the column cant be NULL
+func (doc movieDocument) apply(movie *data.Movie) {
+	movie.Title = doc.Title
+	movie.Year = doc.Year
+	movie.Runtime = doc.Runtime
+	movie.Genres = data.NormalizeGenres(doc.Genres)
+	movie.Synopsis = doc.Synopsis
+	movie.OriginalTitle = doc.OriginalTitle
+	movie.OriginalLanguage = doc.OriginalLanguage
+	movie.SpokenLanguages = doc.SpokenLanguages
+	if movie.SpokenLanguages == nil {
+		movie.SpokenLanguages = []string{}
+	}
+	movie.Certifications = doc.Certifications
+	movie.ExternalIDs = doc.ExternalIDs
+}
+
+// patchMovie applies a PATCH /v1/movies/:id body to movie. Merge patches and JSON
+// patches run against movieDocument, anything else is read as updateMovieInput
+func (app *application) patchMovie(w http.ResponseWriter, r *http.Request, movie *data.Movie) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != mergePatchContentType && mediaType != jsonPatchContentType {
+		//input struct to hold expected data from client
+		//pointers to enable partial updates, see updateMovieInput
+		var input updateMovieInput
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			return err
+		}
+		//copy stuff form request body to fields of movie record
+		input.apply(movie)
+		return nil
+	}
+
+	//same 1mb limit as readJSON
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1_048_576))
+	if err != nil {
+		return err
+	}
+	if len(body) == 0 {
+		return errors.New("body must not be empty")
+	}
+	doc, err := json.Marshal(newMovieDocument(movie))
+	if err != nil {
+		return err
+	}
+
+	switch mediaType {
+	case mergePatchContentType:
+		doc, err = jsonpatch.MergePatch(doc, body)
+	default:
+		var patch jsonpatch.Patch
+		patch, err = jsonpatch.Decode(body)
+		if err != nil {
+			return err
+		}
+		doc, err = patch.Apply(doc)
+	}
+	if err != nil {
+		return err
+	}
+
+	//the patched document goes through the same strict decoding as a request body,
+	//so adding a key like "id" is an unknown field
+	var patched movieDocument
+	err = decodeJSON(bytes.NewReader(doc), &patched)
+	if err != nil {
+		return err
+	}
+	patched.apply(movie)
+	return nil
+}
+
 // createmovie handler for POST /v1/movies endpoint
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
-	//declare anon struct to hold info we expect to be in the http body
+	//declare struct to hold info we expect to be in the http body
 	//Struct will be the *target decode destination
-	var input struct {
-		Title   string       `json:"title"`
-		Year    int32        `json:"year"`
-		Runtime data.Runtime `json:"runtime"`
-		Genres  []string     `json:"genres"`
-	}
+	var input createMovieInput
 	//init new decoder to read from request body and put into input struct
 	//if error during decoder send 400 reponse using our custom errs
 	//Decode must be non-nil pointer, if no pointer you get invalidunmarhshalerror at runtime
@@ -29,11 +240,13 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		app.badRequestResponse(w, r, err)
 		return
 	}
-	movie := &data.Movie{
-		Title:   input.Title,
-		Year:    input.Year,
-		Runtime: input.Runtime,
-		Genres:  input.Genres,
+	movie := input.movie()
+
+	//genres are checked against the managed list in the genres table
+	knownGenres, err := app.models.Genres.Slugs()
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+		return
 	}
 
 	v := validator.New()
@@ -42,14 +255,14 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	//use Valid Method to check if any blocks have failed. If they did
 	//use failedValidationReponse helper to send reponse to client
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if data.ValidateMovie(v, movie, knownGenres); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
 	// insert() passes a pointer to validated movie struct.
 	// we create a record in the DB and update the struct with new info
-	err = app.models.Movies.Insert(movie)
+	err = app.movies(r).Insert(movie)
 	if err != nil {
 		app.serverErrorReponse(w, r, err)
 		return
@@ -62,7 +275,7 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	headers.Set("Locaton", fmt.Sprintf("/v1/movies/%d", movie.ID))
 
 	// write JSON response with 201 code status
-	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorReponse(w, r, err)
 	}
@@ -81,10 +294,22 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		app.notFoundResponse(w, r) //goes to errors.go
 		return
 	}
+	//?fields= trims the movie, release dates and series are embedded unless ?include= says otherwise
+	v := validator.New()
+	fields, include := app.readMovieFields(r.URL.Query(), data.MovieDefaultIncludes, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 	//use get method in internal/movies.go to get data for movie
 	//also use errors func to check if we return err recordnotfound errr
 	//if that happens, return 404 to client.
-	movie, err := app.models.Movies.Get(id)
+	//version always gets loaded, the ETag needs it
+	columns := fields
+	if len(fields) > 0 {
+		columns = append([]string{"version"}, fields...)
+	}
+	movie, err := app.models.Movies.Get(id, columns...)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -94,14 +319,56 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		}
 		return
 	}
+	//release dates, series, credits and ratings live in their own tables
+	err = app.embedMovies([]*data.Movie{movie}, include)
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+		return
+	}
 	//Encode struct above to json and punch it
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	env := envelope{"movie": movie}
+	err = env.project("movie", projectMovieFields(fields, include))
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+		return
+	}
+	//ETag lets clients revalidate with If-None-Match and send If-Match on writes
+	err = app.writeResponseTagged(w, r, http.StatusOK, env, movieETag(movie.Version))
 	if err != nil {
 		app.serverErrorReponse(w, r, err) //Goes to error.Go we set up
 
 	}
 }
 
+// GET /v1/movies/by-external/:source/:id, looks a movie up by its ID on another site
+func (app *application) showMovieByExternalIDHandler(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	source := params.ByName("source")
+	externalID := params.ByName("id")
+
+	v := validator.New()
+	if data.ValidateExternalID(v, "id", source, externalID); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.models.Movies.GetByExternalID(source, externalID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorReponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+	}
+}
+
 func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Request) {
 	//get movieid from URL
 	id, err := app.readIDParam(r)
@@ -120,58 +387,50 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		}
 		return
 	}
-	//input struct to hold expected data from client
-	//added pointers to enable partial updates as before we did not allow nil in fields
-	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
+	//the client has to prove it saw the current version, 428 without If-Match, 412 if stale
+	if !app.checkIfMatch(w, r, movie.Version) {
+		return
 	}
-	//read Json request body data and put into our above input struct
-	err = app.readJSON(w, r, &input)
+	//plain JSON, merge patch or JSON patch depending on Content-Type, see patchMovie
+	err = app.patchMovie(w, r, movie)
 	if err != nil {
-		app.badRequestResponse(w, r, err)
+		switch {
+		case errors.Is(err, jsonpatch.ErrTestFailed), errors.Is(err, jsonpatch.ErrPathNotFound):
+			app.patchConflictResponse(w, r, err)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
 		return
 	}
-	//if input.title is nil then we know no title keyword provided by req
-	//as .title is now a pointer to a string, we can use the * operator to get the value
-	if input.Title != nil {
-		movie.Title = *input.Title
-	}
-	//do same for other fields in the input struct
-	if input.Year != nil {
-		movie.Year = *input.Year
-	}
-	if input.Runtime != nil {
-		movie.Runtime = *input.Runtime
-	}
-	if input.Genres != nil {
-		movie.Genres = input.Genres
+
+	knownGenres, err := app.models.Genres.Slugs()
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+		return
 	}
-	//copy stuff form request body to fields of movie record
 
 	//Validate the movie record, send 422 unprocessable if ANY check fails here
 	v := validator.New()
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if data.ValidateMovie(v, movie, knownGenres); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
 	//pass updated movie record into our new Update() method
-	err = app.models.Movies.Update(movie)
+	err = app.movies(r).Update(movie)
 	if err != nil {
 		switch {
+		//someone else got in between our Get and Update, the If-Match version is stale now
 		case errors.Is(err, data.ErrEditConflict):
-			app.editConflictResponse(w, r)
+			app.preconditionFailedResponse(w, r)
 		default:
 			app.serverErrorReponse(w, r, err)
 		}
 		return
 	}
 	//final step
-	//write updated movie record in JSON response
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	//write updated movie record in JSON response, the new ETag is good for the next write
+	err = app.writeResponseTagged(w, r, http.StatusOK, envelope{"movie": movie}, movieETag(movie.Version))
 	if err != nil {
 		app.serverErrorReponse(w, r, err)
 	}
@@ -184,19 +443,37 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		app.notFoundResponse(w, r)
 		return
 	}
+	//If-Match is checked against the current version first
+	movie, err := app.models.Movies.Get(id, "version")
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorReponse(w, r, err)
+		}
+		return
+	}
+	if !app.checkIfMatch(w, r, movie.Version) {
+		return
+	}
 	//delete movie from DB, send 404 if no record found
-	err = app.models.Movies.Delete(id)
+	err = app.movies(r).DeleteVersion(id, movie.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.preconditionFailedResponse(w, r)
 		default:
 			app.serverErrorReponse(w, r, err)
 		}
 		return
 	}
+	//trashed movies shouldnt turn up as similar ones before the next refresh
+	app.similar.Remove(id)
 	// return 200 if success
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorReponse(w, r, err)
 	}
@@ -206,8 +483,7 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	//define input struct to hold value from request Query string
 	var input struct {
-		Title        string
-		Genres       []string
+		data.MovieFilter
 		data.Filters //new struct found from filters.go
 	}
 	//ini new validator instance
@@ -216,36 +492,121 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	//call URL.Query to get map with query string data
 	qs := r.URL.Query()
 
-	//use helpers to get title and genres string values
-	//use defaults if non provided by client
-	input.Title = app.readString(qs, "title", "")
-	input.Genres = app.readCSV(qs, "genres", []string{})
+	//title, genres, ranges etc, see readMovieFilter
+	input.MovieFilter = app.readMovieFilter(qs, v)
+	//fuzzy matches come best first unless the client picked a sort
+	input.MovieFilter.RankBySimilarity = input.MovieFilter.Fuzzy && !qs.Has("sort")
 
 	//get page and pagesize values as int
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 
-	//Extract the sort query string value, falling back to ID if not provided
+	//Extract the sort query string value, falling back to ID if not provided.
+	//Can be several keys like sort=-year,title
 	input.Filters.Sort = app.readString(qs, "sort", "id")
 	//supported safelist values for this endpoint
-	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "runtime"}
+	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+
+	//keyset pagination is opt in, sending cursor (empty for the first page) turns it on
+	input.Filters.UseCursor = qs.Has("cursor")
+	if cursor := qs.Get("cursor"); cursor != "" {
+		c, err := data.DecodeCursor(cursor, app.config.cursor.secret)
+		if err != nil {
+			v.AddError("cursor", "invalid cursor")
+		}
+		input.Filters.Cursor = c
+	}
+	//the total is on by default for page numbers, off for cursors
+	input.Filters.WithTotal = app.readBool(qs, "total", !input.Filters.UseCursor, v)
+	v.Check(!input.Filters.UseCursor || !input.MovieFilter.RankBySimilarity, "cursor", "cannot be combined with fuzzy unless sort is given")
+
+	//nothing is embedded in lists unless asked for
+	fields, include := app.readMovieFields(qs, nil, v)
+	//facets=true adds counts for the filter sidebar
+	withFacets := app.readBool(qs, "facets", false, v)
 
 	//check validator instance for any errors, act if any
+	data.ValidateMovieFilter(v, input.MovieFilter)
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
 	//call getall method to get movies, passing in filters if needed
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.GetAll(input.MovieFilter, input.Filters, fields...)
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+		return
+	}
+	if metadata.Next != nil {
+		metadata.NextCursor = metadata.Next.Encode(app.config.cursor.secret)
+	}
+	err = app.embedMovies(movies, include)
 	if err != nil {
 		app.serverErrorReponse(w, r, err)
 		return
 	}
 	//send JSOn response with all movie data, our main API function
-	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	env := envelope{"movies": movies, "metadata": metadata}
+	err = env.project("movies", projectMovieFields(fields, include))
 	if err != nil {
 		app.serverErrorReponse(w, r, err)
+		return
+	}
+	if withFacets {
+		env["facets"], err = app.models.Movies.Facets(input.MovieFilter)
+		if err != nil {
+			app.serverErrorReponse(w, r, err)
+			return
+		}
+	}
+	err = app.writeResponseTagged(w, r, http.StatusOK, env, bodyETag)
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+	}
+
+}
+
+// readMovieFilter pulls the movie list filters out of the query string, problems go to v.
+// Run data.ValidateMovieFilter on the result before using it
+func (app *application) readMovieFilter(qs url.Values, v *validator.Validator) data.MovieFilter {
+	return data.MovieFilter{
+		Title: app.readString(qs, "title", ""),
+		//fuzzy=true forgives typos in title
+		Fuzzy: app.readBool(qs, "fuzzy", false, v),
+		//genres matches all listed by default, genres_mode=any matches any of them
+		Genres:        data.NormalizeGenres(app.readCSV(qs, "genres", []string{})),
+		GenresMode:    app.readString(qs, "genres_mode", data.GenresModeAll),
+		ExcludeGenres: data.NormalizeGenres(app.readCSV(qs, "-genres", []string{})),
+		YearMin:       app.readOptionalInt(qs, "year_min", v),
+		YearMax:       app.readOptionalInt(qs, "year_max", v),
+		RuntimeMin:    app.readOptionalInt(qs, "runtime_min", v),
+		RuntimeMax:    app.readOptionalInt(qs, "runtime_max", v),
+		CreatedAfter:  app.readTime(qs, "created_after", v),
+	}
+}
+
+// GET /v1/movies/autocomplete?q=, title suggestions for search boxes
+func (app *application) autocompleteMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	q := strings.TrimSpace(app.readString(qs, "q", ""))
+	limit := app.readInt(qs, "limit", 10, v)
+
+	if data.ValidateAutocomplete(v, q, limit); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
 	}
 
+	suggestions, err := app.models.Movies.Autocomplete(q, limit)
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"suggestions": suggestions}, nil)
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+	}
 }
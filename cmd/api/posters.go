@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/images"
+	"greenlight.alexedwards.net/internal/jsonlog"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// posters get their own limits, readJSON's 1MB is far too small for images
+const (
+	maxPosterBytes     = 10 << 20 //10MB
+	minPosterDimension = 100
+	maxPosterDimension = 6000
+	thumbnailWidth     = 300
+)
+
+// PUT /v1/movies/:id/poster, takes a multipart form with the image in a "poster" field
+func (app *application) updateMoviePosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorReponse(w, r, err)
+		}
+		return
+	}
+
+	img, err := app.readPoster(w, r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	//sniff the type and check the size from the header before decoding everything
+	v := validator.New()
+	contentType, err := images.DetectType(img)
+	if err != nil {
+		v.AddError("poster", "must be a JPEG, PNG or GIF image")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	width, height, err := images.Dimensions(img)
+	if err != nil {
+		v.AddError("poster", "could not read image dimensions")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	v.Check(width >= minPosterDimension && height >= minPosterDimension, "poster", fmt.Sprintf("must be at least %dx%d pixels", minPosterDimension, minPosterDimension))
+	v.Check(width <= maxPosterDimension && height <= maxPosterDimension, "poster", fmt.Sprintf("must be at most %dx%d pixels", maxPosterDimension, maxPosterDimension))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	decoded, err := images.Decode(img, contentType)
+	if err != nil {
+		v.AddError("poster", "could not decode image")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	thumbnail, err := images.Thumbnail(decoded, thumbnailWidth)
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+		return
+	}
+
+	//random part in the key so caches never serve an old poster
+	suffix := make([]byte, 8)
+	_, err = rand.Read(suffix)
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+		return
+	}
+	base := fmt.Sprintf("posters/%d/%s", movie.ID, hex.EncodeToString(suffix))
+	originalKey := base + images.Extensions[contentType]
+	thumbnailKey := base + "_thumb.jpg"
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	err = app.blobs.Put(ctx, originalKey, img, contentType)
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+		return
+	}
+	err = app.blobs.Put(ctx, thumbnailKey, thumbnail, "image/jpeg")
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+		return
+	}
+
+	oldURLs := []string{movie.PosterURL, movie.PosterThumbnailURL}
+	movie.PosterURL = app.blobs.URL(originalKey)
+	movie.PosterThumbnailURL = app.blobs.URL(thumbnailKey)
+
+	err = app.movies(r).UpdatePoster(movie)
+	if err != nil {
+		//the new files are orphans now, tidy them up
+		app.deleteBlobs(app.contextGetRequestID(r), movie.PosterURL, movie.PosterThumbnailURL)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorReponse(w, r, err)
+		}
+		return
+	}
+	app.deleteBlobs(app.contextGetRequestID(r), oldURLs...)
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+	}
+}
+
+// readPoster streams the multipart body and returns the bytes of the "poster" part
+func (app *application) readPoster(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	//a bit of headroom on top of the image for the multipart boundaries
+	r.Body = http.MaxBytesReader(w, r.Body, maxPosterBytes+64*1024)
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, errors.New("body must be multipart/form-data")
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, errors.New("body must contain a poster field")
+		}
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				return nil, fmt.Errorf("poster must not be larger than %d bytes", maxPosterBytes)
+			}
+			return nil, errors.New("body contains badly-formed multipart data")
+		}
+		if part.FormName() != "poster" {
+			continue
+		}
+
+		img, err := io.ReadAll(io.LimitReader(part, maxPosterBytes+1))
+		if err != nil {
+			return nil, errors.New("body contains badly-formed multipart data")
+		}
+		if len(img) > maxPosterBytes {
+			return nil, fmt.Errorf("poster must not be larger than %d bytes", maxPosterBytes)
+		}
+		if len(img) == 0 {
+			return nil, errors.New("poster must not be empty")
+		}
+		return img, nil
+	}
+}
+
+// deleteBlobs removes old poster files in the background, failures just get logged
+// against requestID, "" when it isnt for a request
+func (app *application) deleteBlobs(requestID string, urls ...string) {
+	prefix := app.blobs.URL("")
+	app.background(requestID, func(logger *jsonlog.Logger) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		for _, url := range urls {
+			if url == "" || !strings.HasPrefix(url, prefix) {
+				continue
+			}
+			err := app.blobs.Delete(ctx, strings.TrimPrefix(url, prefix))
+			if err != nil {
+				logger.PrintError(err, map[string]string{"blob": url})
+			}
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// PUT /v1/movies/:id/release-dates, replaces the whole set of release dates for a movie
+func (app *application) updateMovieReleaseDatesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		ReleaseDates []data.ReleaseDate `json:"release_dates"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateReleaseDates(v, input.ReleaseDates); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.ReleaseDates.Replace(id, input.ReleaseDates)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorReponse(w, r, err)
+		}
+		return
+	}
+
+	//read them back so the order matches GET
+	dates, err := app.models.ReleaseDates.GetForMovie(id)
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"release_dates": dates}, nil)
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+	}
+}
+
+// GET /v1/movies/upcoming, movies with a release still to come, soonest first
+func (app *application) listUpcomingMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Region string
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Region = strings.ToUpper(app.readString(qs, "region", ""))
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	//always ordered by the next release date
+	input.Filters.Sort = "release_date"
+	input.Filters.SortSafelist = []string{"release_date"}
+
+	if input.Region != "" {
+		v.Check(validator.Matches(input.Region, data.CountryCodeRX), "region", "must be an ISO 3166-1 country code")
+	}
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.models.ReleaseDates.Upcoming(input.Region, input.Filters)
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// movies is the movie model with writes recorded against the user making the request,
+// use it for anything that changes the movies table so movie_revisions knows who did it
+func (app *application) movies(r *http.Request) data.MovieModel {
+	return app.models.Movies.As(app.contextGetUser(r).ID)
+}
+
+// GET /v1/movies/:id/revisions, newest first with the fields each one changed. The
+// history of a trashed or purged movie is only there for movies:write, like the
+// trash itself, everyone else gets a 404
+func (app *application) listMovieRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Movies.Get(id, "id")
+	if err != nil {
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorReponse(w, r, err)
+			return
+		}
+		permissions, err := app.models.Permissions.GetAllForUser(app.contextGetUser(r).ID)
+		if err != nil {
+			app.serverErrorReponse(w, r, err)
+			return
+		}
+		if !permissions.Include("movies:write") {
+			app.notFoundResponse(w, r)
+			return
+		}
+	}
+
+	var input struct {
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	//always newest first
+	input.Filters.Sort = "-version"
+	input.Filters.SortSafelist = []string{"-version"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	revisions, metadata, err := app.models.Revisions.GetAll(id, input.Filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorReponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"revisions": revisions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+	}
+}
+
+// POST /v1/movies/:id/revisions/:version/revert, puts the movie back how it was at
+// version as a new version. Same If-Match rules as PATCH, posters are left alone
+func (app *application) revertMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	version, err := strconv.ParseInt(httprouter.ParamsFromContext(r.Context()).ByName("version"), 10, 32)
+	if err != nil || version < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	//movies in the trash have to be restored first
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorReponse(w, r, err)
+		}
+		return
+	}
+	if !app.checkIfMatch(w, r, movie.Version) {
+		return
+	}
+
+	reverted, err := app.models.Revisions.Snapshot(id, int32(version))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorReponse(w, r, err)
+		}
+		return
+	}
+	reverted.CreatedAt = movie.CreatedAt
+	reverted.Version = movie.Version
+	reverted.PosterURL = movie.PosterURL
+	reverted.PosterThumbnailURL = movie.PosterThumbnailURL
+
+	knownGenres, err := app.models.Genres.Slugs()
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+		return
+	}
+
+	//the old version can fail todays rules, like a genre that has since been removed
+	v := validator.New()
+	if data.ValidateMovie(v, reverted, knownGenres); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.movies(r).Update(reverted)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.preconditionFailedResponse(w, r)
+		default:
+			app.serverErrorReponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponseTagged(w, r, http.StatusOK, envelope{"movie": reverted}, movieETag(reverted.Version))
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+	}
+}
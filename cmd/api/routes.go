@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
+	"greenlight.alexedwards.net/internal/blobstore"
 )
 
 func (app *application) routes() http.Handler {
@@ -24,6 +25,40 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
 	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/poster", app.requirePermission("movies:write", app.updateMoviePosterHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/release-dates", app.requirePermission("movies:write", app.updateMovieReleaseDatesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/similar", app.requirePermission("movies:read", app.listSimilarMoviesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/credits", app.requirePermission("movies:read", app.listMovieCreditsHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/credits", app.requirePermission("movies:write", app.updateMovieCreditsHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/rating", app.requirePermission("movies:read", app.rateMovieHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/rating", app.requirePermission("movies:read", app.deleteMovieRatingHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/restore", app.requirePermission("movies:write", app.restoreMovieHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions", app.requirePermission("movies:read", app.listMovieRevisionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/revisions/:version/revert", app.requirePermission("movies:write", app.revertMovieHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/search", app.requirePermission("movies:read", app.searchHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/genres", app.requirePermission("movies:read", app.listGenresHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/series", app.requirePermission("movies:read", app.listSeriesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/series", app.requirePermission("movies:write", app.createSeriesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/series/:id", app.requirePermission("movies:read", app.showSeriesHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/series/:id", app.requirePermission("movies:write", app.updateSeriesHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/series/:id", app.requirePermission("movies:write", app.deleteSeriesHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/series/:id/movies", app.requirePermission("movies:write", app.replaceSeriesMoviesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/series/:id/movies", app.requirePermission("movies:write", app.addSeriesMovieHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/series/:id/movies/:movie_id", app.requirePermission("movies:write", app.removeSeriesMovieHandler))
+
+	//httprouter won't let static segments sit next to the :id wildcard, so fixed
+	//paths under /v1/movies/ go on their own router which is tried first
+	collections := httprouter.New()
+	collections.HandlerFunc(http.MethodGet, "/v1/movies/by-external/:source/:id", app.requirePermission("movies:read", app.showMovieByExternalIDHandler))
+	collections.HandlerFunc(http.MethodGet, "/v1/movies/upcoming", app.requirePermission("movies:read", app.listUpcomingMoviesHandler))
+	collections.HandlerFunc(http.MethodGet, "/v1/movies/autocomplete", app.requirePermission("movies:read", app.autocompleteMoviesHandler))
+	collections.HandlerFunc(http.MethodPost, "/v1/movies/batch", app.requirePermission("movies:write", app.batchMoviesHandler))
+	collections.HandlerFunc(http.MethodGet, "/v1/movies/trash", app.requirePermission("movies:write", app.listTrashHandler))
+	collections.HandlerFunc(http.MethodGet, "/v1/movies/export", app.requirePermission("movies:read", app.exportMoviesHandler))
+	collections.HandlerFunc(http.MethodPost, "/v1/movies/import", app.requirePermission("movies:write", app.importMoviesHandler))
 
 	//Route below for POST users endpoint to create a user
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
@@ -31,10 +66,36 @@ func (app *application) routes() http.Handler {
 	//
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
 
+	//the signed in users own ratings, watchlist and what we recommend from them
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/ratings", app.requirePermission("movies:read", app.listMyRatingsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/watchlist", app.requirePermission("movies:read", app.listWatchlistHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/watchlist/:id", app.requirePermission("movies:read", app.addToWatchlistHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/watchlist/:id", app.requirePermission("movies:read", app.removeFromWatchlistHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/recommendations", app.requirePermission("movies:read", app.listRecommendationsHandler))
+
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/audit", app.requirePermission("audit:read", app.listAuditHandler))
+
+	//when uploads are on local disk we serve them ourselves, S3 serves its own
+	if local, ok := app.blobs.(*blobstore.LocalStore); ok {
+		router.ServeFiles("/uploads/*filepath", http.Dir(local.Dir()))
+	}
 	//added memstats, lets us do momentintime snapshots on mem use, its all in bytes
 	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
 	//return routerhttp instance
 	//we put enableCORS early in the chain, after Ratelimiter to help blocking
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	//compress goes straight inside metrics so it counts the compressed bytes
+	return app.metrics(app.requestID(app.compress(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(app.idempotency(preferRouter(collections, router)))))))))
+}
+
+// preferRouter sends the request to first if it has a route for it, otherwise to fallback
+func preferRouter(first *httprouter.Router, fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handle, _, _ := first.Lookup(r.Method, r.URL.Path); handle != nil {
+			first.ServeHTTP(w, r)
+			return
+		}
+		fallback.ServeHTTP(w, r)
+	})
 }
@@ -47,6 +47,8 @@ func (app *application) serve() error {
 		if err != nil {
 			shutdownError <- err
 		}
+		//tell the periodic jobs to stop so wg.Wait below can finish
+		close(app.shutdown)
 		//log message that were waiting for background goroutines to complete
 		app.logger.PrintInfo("Completing background tasks", map[string]string{
 			"addr": srv.Addr,
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/similar"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// GET /v1/movies/:id/similar, "more like this" from the in-memory model. Scores on
+// genres, shared cast and crew, year and users who liked both
+func (app *application) listSimilarMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	limit := app.readInt(r.URL.Query(), "limit", 10, v)
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 50, "limit", "must be a max of 50")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//the movie itself comes from the db so edits since the last refresh count
+	movie, err := app.models.Movies.Get(id, "title", "year", "genres")
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorReponse(w, r, err)
+		}
+		return
+	}
+
+	people, err := app.models.Credits.GetForMovie(id)
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+		return
+	}
+	target := similarMovie(movie)
+	for _, credit := range people {
+		target.People = append(target.People, credit.Name)
+	}
+
+	matches := app.similar.Similar(target, limit)
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movies": matches}, nil)
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+	}
+}
+
+// GET /v1/users/me/recommendations, movies like the ones the user rated well or put
+// on their watchlist, and unlike the ones they rated badly. Movies they have already
+// rated or listed are left out
+func (app *application) listRecommendationsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	limit := app.readInt(r.URL.Query(), "limit", 10, v)
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 50, "limit", "must be a max of 50")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	//the users own opinions come from the db so they count straight away
+	ratings, err := app.models.Ratings.GetForUser(user.ID)
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+		return
+	}
+	watchlist, err := app.models.Watchlist.MovieIDs(user.ID)
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+		return
+	}
+
+	seeds := make([]similar.Seed, 0, len(ratings)+len(watchlist))
+	rated := make(map[int64]bool, len(ratings))
+	for _, rating := range ratings {
+		seeds = append(seeds, similar.RatingSeed(rating.MovieID, rating.Rating))
+		rated[rating.MovieID] = true
+	}
+	for _, id := range watchlist {
+		//a rating says more than being on the list
+		if !rated[id] {
+			seeds = append(seeds, similar.WatchlistSeed(id))
+		}
+	}
+
+	matches := app.similar.Recommend(seeds, limit)
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movies": matches}, nil)
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+	}
+}
+
+// refreshSimilar reloads the similar movies model, run by every() from main
+func (app *application) refreshSimilar() {
+	movies, err := app.models.Movies.All("title", "year", "genres")
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+	people, err := app.models.Credits.Names()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+	ratings, err := app.models.Ratings.All()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	snapshot := make([]similar.Movie, len(movies))
+	for i, movie := range movies {
+		snapshot[i] = similarMovie(movie)
+		snapshot[i].People = people[movie.ID]
+	}
+	snapshotRatings := make([]similar.Rating, len(ratings))
+	for i, rating := range ratings {
+		snapshotRatings[i] = similar.Rating{UserID: rating.UserID, MovieID: rating.MovieID, Rating: rating.Rating}
+	}
+	app.similar.Load(snapshot, snapshotRatings)
+}
+
+func similarMovie(movie *data.Movie) similar.Movie {
+	return similar.Movie{ID: movie.ID, Title: movie.Title, Year: movie.Year, Genres: movie.Genres}
+}
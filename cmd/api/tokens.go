@@ -40,6 +40,7 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			app.audit(r, data.AuditLoginFailed, 0, map[string]string{"email": input.Email, "reason": "unknown email"})
 			app.invalidCredentialsResponse(w, r)
 		default:
 			app.serverErrorReponse(w, r, err)
@@ -57,6 +58,7 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	// If the passwords don't match, then we call the app.invalidCredentialsResponse()
 	// helper again and return.
 	if !match {
+		app.audit(r, data.AuditLoginFailed, user.ID, map[string]string{"email": input.Email, "reason": "wrong password"})
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
@@ -68,10 +70,12 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		app.serverErrorReponse(w, r, err)
 		return
 	}
+	app.audit(r, data.AuditLoginSucceeded, user.ID, nil)
+	app.audit(r, data.AuditTokenIssued, user.ID, map[string]string{"scope": data.ScopeAuthentication})
 
 	// Encode the token to JSON and send it in the response along with a 201 Created
 	// status code.
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"authentication_token": token}, nil)
 	if err != nil {
 		app.serverErrorReponse(w, r, err)
 	}
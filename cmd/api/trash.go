@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// GET /v1/movies/trash, deleted movies that havent been purged yet
+func (app *application) listTrashHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	//always most recently deleted first
+	input.Filters.Sort = "deleted_at"
+	input.Filters.SortSafelist = []string{"deleted_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.models.Movies.GetTrash(input.Filters)
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+	}
+}
+
+// POST /v1/movies/:id/restore, takes a movie back out of the trash
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.movies(r).Restore(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorReponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponseTagged(w, r, http.StatusOK, envelope{"movie": movie}, movieETag(movie.Version))
+	if err != nil {
+		app.serverErrorReponse(w, r, err)
+	}
+}
+
+// purgeTrash permanently deletes movies that have been in the trash longer than
+// -trash-retention, run by every() from main
+func (app *application) purgeTrash() {
+	purged, err := app.models.Movies.PurgeTrash(time.Now().Add(-app.config.trash.retention))
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+	if len(purged) == 0 {
+		return
+	}
+
+	var posters []string
+	for _, movie := range purged {
+		posters = append(posters, movie.PosterURL, movie.PosterThumbnailURL)
+	}
+	app.deleteBlobs("", posters...)
+
+	app.logger.PrintInfo("purged movies from the trash", map[string]string{"count": strconv.Itoa(len(purged))})
+}
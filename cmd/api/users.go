@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"greenlight.alexedwards.net/internal/data"
+	"greenlight.alexedwards.net/internal/jsonlog"
 	"greenlight.alexedwards.net/internal/validator"
 )
 
@@ -73,15 +74,18 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		app.serverErrorReponse(w, r, err)
 		return
 	}
+	app.audit(r, data.AuditPermissionGranted, user.ID, map[string]string{"permission": "movies:read"})
+
 	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
 	if err != nil {
 		app.serverErrorReponse(w, r, err)
 		return
 	}
+	app.audit(r, data.AuditTokenIssued, user.ID, map[string]string{"scope": data.ScopeActivation})
 
 	// Use the background helper to execute an anonymous function that sends the welcome
 	// email.
-	app.background(func() {
+	app.background(app.contextGetRequestID(r), func(logger *jsonlog.Logger) {
 		data := map[string]interface{}{
 			"activationToken": token.Plaintext,
 			"userID":          user.ID,
@@ -90,7 +94,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 
 		err = app.mailer.Send(user.Email, "user_welcome.tmpl", data)
 		if err != nil {
-			app.logger.PrintError(err, nil)
+			logger.PrintError(err, nil)
 		}
 	})
 
@@ -100,7 +104,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 
 	// Write a JSON response containing the user data along with a 201 Created status
 	// code.
-	err = app.writeJSON(w, http.StatusAccepted, envelope{"user": user}, nil)
+	err = app.writeResponse(w, r, http.StatusAccepted, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorReponse(w, r, err)
 	}
@@ -147,14 +151,16 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		}
 		return
 	}
+	app.audit(r, data.AuditUserActivated, user.ID, nil)
 	//if success, delete activation tokens for user
 	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
 	if err != nil {
 		app.serverErrorReponse(w, r, err)
 		return
 	}
+	app.audit(r, data.AuditTokenRevoked, user.ID, map[string]string{"scope": data.ScopeActivation})
 	//send updated details to client in JSON
-	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorReponse(w, r, err)
 	}
@@ -0,0 +1,35 @@
+package blobstore
+
+//Somewhere to keep uploaded files like posters. The API only talks to the
+//BlobStore interface so we can swap local disk for S3 (or minio etc) with a flag
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+var ErrInvalidKey = errors.New("invalid blob key")
+
+type BlobStore interface {
+	// Put stores data under key, overwriting anything already there
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Delete removes key, deleting a missing key is not an error
+	Delete(ctx context.Context, key string) error
+	// URL is the public address clients use to fetch key
+	URL(key string) string
+}
+
+// keys are slash separated paths like "posters/12/abc.jpg", we dont allow
+// anything that could climb out of the store
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
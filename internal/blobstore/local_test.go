@@ -0,0 +1,47 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalPutAndDelete(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewLocal(filepath.Join(dir, "blobs"), "http://localhost:4000/v1/blobs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	err = store.Put(ctx, "posters/12/abc.jpg", []byte("jpeg bytes"), "image/jpeg")
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(store.Dir(), "posters", "12", "abc.jpg"))
+	if err != nil || string(got) != "jpeg bytes" {
+		t.Fatalf("file holds %q, %v", got, err)
+	}
+
+	if url := store.URL("posters/12/abc.jpg"); url != "http://localhost:4000/v1/blobs/posters/12/abc.jpg" {
+		t.Errorf("URL = %q", url)
+	}
+
+	err = store.Delete(ctx, "posters/12/abc.jpg")
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(store.Dir(), "posters", "12", "abc.jpg")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("file still there after Delete: %v", err)
+	}
+	err = store.Delete(ctx, "posters/12/abc.jpg")
+	if err != nil {
+		t.Errorf("Delete of a missing key: %v", err)
+	}
+
+	if err := store.Put(ctx, "../escape.jpg", []byte("x"), ""); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Put outside the store = %v, want ErrInvalidKey", err)
+	}
+}
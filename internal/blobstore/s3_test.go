@@ -0,0 +1,176 @@
+package blobstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 is just enough of the S3 API for S3Store, path style PUT and DELETE of
+// objects held in memory. It checks the parts of the signature it can without the
+// secret, the payload hash and the credential scope
+type fakeS3 struct {
+	t       *testing.T
+	bucket  string
+	mu      sync.Mutex
+	objects map[string]fakeObject
+}
+
+type fakeObject struct {
+	data        []byte
+	contentType string
+}
+
+func newFakeS3(t *testing.T, bucket string) (*fakeS3, *httptest.Server) {
+	f := &fakeS3{t: t, bucket: bucket, objects: map[string]fakeObject{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	if got := r.Header.Get("X-Amz-Content-Sha256"); got != hex.EncodeToString(sum[:]) {
+		http.Error(w, "XAmzContentSHA256Mismatch", http.StatusBadRequest)
+		return
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=access/") ||
+		!strings.Contains(auth, "/us-east-1/s3/aws4_request, SignedHeaders=") ||
+		!strings.Contains(auth, ", Signature=") {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+		return
+	}
+
+	prefix := "/" + f.bucket + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, prefix)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		f.objects[key] = fakeObject{data: body, contentType: r.Header.Get("Content-Type")}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeS3) object(key string) (fakeObject, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	obj, ok := f.objects[key]
+	return obj, ok
+}
+
+func newTestS3(srv *httptest.Server, bucket string) *S3Store {
+	return NewS3(S3Config{
+		Endpoint:  srv.URL + "/",
+		Region:    "us-east-1",
+		Bucket:    bucket,
+		AccessKey: "access",
+		SecretKey: "secret",
+	})
+}
+
+func TestS3PutAndDelete(t *testing.T) {
+	fake, srv := newFakeS3(t, "posters")
+	store := newTestS3(srv, "posters")
+	ctx := context.Background()
+
+	err := store.Put(ctx, "posters/12/abc.jpg", []byte("jpeg bytes"), "image/jpeg")
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	obj, ok := fake.object("posters/12/abc.jpg")
+	if !ok {
+		t.Fatal("Put did not store the object")
+	}
+	if string(obj.data) != "jpeg bytes" || obj.contentType != "image/jpeg" {
+		t.Errorf("stored %q as %q, want %q as %q", obj.data, obj.contentType, "jpeg bytes", "image/jpeg")
+	}
+
+	err = store.Delete(ctx, "posters/12/abc.jpg")
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, ok := fake.object("posters/12/abc.jpg"); ok {
+		t.Error("Delete left the object in place")
+	}
+
+	//S3 says 204 for missing keys too
+	err = store.Delete(ctx, "posters/12/missing.jpg")
+	if err != nil {
+		t.Errorf("Delete of a missing key: %v", err)
+	}
+}
+
+func TestS3ErrorStatus(t *testing.T) {
+	_, srv := newFakeS3(t, "posters")
+	store := newTestS3(srv, "other-bucket")
+
+	err := store.Put(context.Background(), "a.jpg", []byte("x"), "image/jpeg")
+	if err == nil || !strings.Contains(err.Error(), "404") || !strings.Contains(err.Error(), "NoSuchBucket") {
+		t.Errorf("got %v, want an error with the status and body", err)
+	}
+}
+
+func TestS3InvalidKey(t *testing.T) {
+	_, srv := newFakeS3(t, "posters")
+	store := newTestS3(srv, "posters")
+
+	for _, key := range []string{"", "/abs.jpg", "a/../b.jpg", "a//b.jpg", `a\b.jpg`} {
+		if err := store.Put(context.Background(), key, []byte("x"), ""); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Put(%q) = %v, want ErrInvalidKey", key, err)
+		}
+		if err := store.Delete(context.Background(), key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Delete(%q) = %v, want ErrInvalidKey", key, err)
+		}
+	}
+}
+
+func TestS3URL(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  S3Config
+		want string
+	}{
+		{
+			name: "default public url",
+			cfg:  S3Config{Endpoint: "http://localhost:9000/", Bucket: "posters"},
+			want: "http://localhost:9000/posters/p/1.jpg",
+		},
+		{
+			name: "custom public url",
+			cfg:  S3Config{Endpoint: "https://s3.eu-west-1.amazonaws.com", Bucket: "posters", PublicURL: "https://cdn.example.com/"},
+			want: "https://cdn.example.com/p/1.jpg",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewS3(tt.cfg).URL("p/1.jpg"); got != tt.want {
+				t.Errorf("URL = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// audit event types
+const (
+	AuditLoginSucceeded    = "login.succeeded"
+	AuditLoginFailed       = "login.failed"
+	AuditTokenIssued       = "token.issued"
+	AuditTokenRevoked      = "token.revoked"
+	AuditUserActivated     = "user.activated"
+	AuditPermissionGranted = "permission.granted"
+	AuditPermissionDenied  = "permission.denied"
+)
+
+// AuditEventTypes is every type, for validating the type filter on GET /v1/audit
+var AuditEventTypes = []string{
+	AuditLoginSucceeded,
+	AuditLoginFailed,
+	AuditTokenIssued,
+	AuditTokenRevoked,
+	AuditUserActivated,
+	AuditPermissionGranted,
+	AuditPermissionDenied,
+}
+
+// AuditEvent is one row of the append only audit_events table. UserID is who the event
+// is about, nil when there isnt a known user. Details holds whatever else the type
+// needs, like the permission that was denied
+type AuditEvent struct {
+	ID        int64             `json:"id"`
+	Type      string            `json:"type"`
+	UserID    *int64            `json:"user_id"`
+	IP        string            `json:"ip"`
+	UserAgent string            `json:"user_agent"`
+	RequestID string            `json:"request_id,omitempty"`
+	Details   map[string]string `json:"details"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// AuditFilter narrows GET /v1/audit, empty values mean no filter
+type AuditFilter struct {
+	Type   string
+	UserID *int
+	IP     string
+	Since  *time.Time
+	Until  *time.Time
+}
+
+func ValidateAuditFilter(v *validator.Validator, f AuditFilter) {
+	if f.Type != "" {
+		v.Check(validator.In(f.Type, AuditEventTypes...), "type", "is not a known audit event type")
+	}
+	if f.UserID != nil {
+		v.Check(*f.UserID > 0, "user_id", "must be greater than zero")
+	}
+	if f.Since != nil && f.Until != nil {
+		v.Check(!f.Since.After(*f.Until), "since", "must not be after until")
+	}
+}
+
+func (f AuditFilter) where() *whereBuilder {
+	w := &whereBuilder{}
+	if f.Type != "" {
+		w.add("type = ?", f.Type)
+	}
+	if f.UserID != nil {
+		w.add("user_id = ?", *f.UserID)
+	}
+	if f.IP != "" {
+		w.add("ip = ?", f.IP)
+	}
+	if f.Since != nil {
+		w.add("created_at >= ?", *f.Since)
+	}
+	if f.Until != nil {
+		w.add("created_at < ?", *f.Until)
+	}
+	return w
+}
+
+type AuditModel struct {
+	DB *sql.DB
+}
+
+// Insert records an event, ID and CreatedAt are filled in
+func (m AuditModel) Insert(event *AuditEvent) error {
+	details := event.Details
+	if details == nil {
+		details = map[string]string{}
+	}
+	js, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO audit_events (type, user_id, ip, user_agent, request_id, details)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at`
+
+	args := []interface{}{event.Type, event.UserID, event.IP, event.UserAgent, event.RequestID, string(js)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.CreatedAt)
+}
+
+// GetAll lists matching events newest first
+func (m AuditModel) GetAll(filter AuditFilter, filters Filters) ([]*AuditEvent, Metadata, error) {
+	w := filter.where()
+	query := `
+	SELECT count(*) OVER(), id, type, user_id, ip, user_agent, request_id, details, created_at
+	FROM audit_events
+	WHERE ` + w.String() + `
+	ORDER BY id DESC
+	LIMIT ` + w.placeholder(filters.limit()) + ` OFFSET ` + w.placeholder(filters.offset())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, w.args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	events := []*AuditEvent{}
+	for rows.Next() {
+		var event AuditEvent
+		var details []byte
+		err := rows.Scan(&totalRecords, &event.ID, &event.Type, &event.UserID, &event.IP, &event.UserAgent, &event.RequestID, &details, &event.CreatedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		err = json.Unmarshal(details, &event.Details)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		events = append(events, &event)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	return events, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
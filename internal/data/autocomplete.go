@@ -0,0 +1,58 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// TitleSuggestion is one GET /v1/movies/autocomplete result
+type TitleSuggestion struct {
+	ID    int64   `json:"id"`
+	Title string  `json:"title"`
+	Year  int32   `json:"year,omitempty"`
+	Score float32 `json:"score"` //trigram word similarity, 1 is an exact match
+}
+
+func ValidateAutocomplete(v *validator.Validator, q string, limit int) {
+	v.Check(q != "", "q", "must be provided")
+	v.Check(len(q) <= 100, "q", "must not be more than 100 bytes long")
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 20, "limit", "must be a max of 20")
+}
+
+// Autocomplete returns the titles closest to q, typos and all. It uses word
+// similarity so a few letters match anywhere in a title, not just the start,
+// and the gist trigram index serves the ORDER BY distance LIMIT directly
+func (m MovieModel) Autocomplete(q string, limit int) ([]*TitleSuggestion, error) {
+	query := `
+	SELECT id, title, year, word_similarity($1, title)
+	FROM movies
+	WHERE $1 <% title AND deleted_at IS NULL
+	ORDER BY $1 <<-> title, title
+	LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, q, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []*TitleSuggestion{}
+	for rows.Next() {
+		var s TitleSuggestion
+		err := rows.Scan(&s.ID, &s.Title, &s.Year, &s.Score)
+		if err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, &s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return suggestions, nil
+}
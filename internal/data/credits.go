@@ -0,0 +1,168 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// which side of the camera a credit is
+const (
+	CreditCast = "cast"
+	CreditCrew = "crew"
+)
+
+// Credit is one cast or crew member of a movie, in billing order
+type Credit struct {
+	Name       string `json:"name"`
+	Department string `json:"department"`    //cast or crew
+	Job        string `json:"job,omitempty"` //character for cast, role like Director for crew
+}
+
+func ValidateCredits(v *validator.Validator, credits []Credit) {
+	v.Check(credits != nil, "credits", "must be provided")
+	v.Check(len(credits) <= 500, "credits", "must not contain more than 500 entries")
+
+	for _, c := range credits {
+		v.Check(c.Name != "", "credits", "name must be provided")
+		v.Check(len(c.Name) <= 200, "credits", "name must not be more than 200 bytes long")
+		v.Check(validator.In(c.Department, CreditCast, CreditCrew), "credits", "department must be cast or crew")
+		v.Check(len(c.Job) <= 200, "credits", "job must not be more than 200 bytes long")
+	}
+}
+
+type CreditModel struct {
+	DB *sql.DB
+}
+
+// GetForMovie returns a movies credits in billing order
+func (m CreditModel) GetForMovie(movieID int64) ([]Credit, error) {
+	byMovie, err := m.GetForMovies([]int64{movieID})
+	if err != nil {
+		return nil, err
+	}
+	return byMovie[movieID], nil
+}
+
+// GetForMovies is GetForMovie for a page of movies in one query, every id gets an
+// entry even if it has no credits
+func (m CreditModel) GetForMovies(movieIDs []int64) (map[int64][]Credit, error) {
+	query := `
+	SELECT movie_id, name, department, job
+	FROM movie_credits
+	WHERE movie_id = ANY($1)
+	ORDER BY movie_id, position`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byMovie := make(map[int64][]Credit, len(movieIDs))
+	for _, id := range movieIDs {
+		byMovie[id] = []Credit{}
+	}
+	for rows.Next() {
+		var movieID int64
+		var c Credit
+		err := rows.Scan(&movieID, &c.Name, &c.Department, &c.Job)
+		if err != nil {
+			return nil, err
+		}
+		byMovie[movieID] = append(byMovie[movieID], c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return byMovie, nil
+}
+
+// Replace swaps a movies whole set of credits in one transaction, ErrRecordNotFound
+// if the movie doesnt exist or is in the trash
+func (m CreditModel) Replace(movieID int64, credits []Credit) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	//rollback is a no-op once commit has happened
+	defer tx.Rollback()
+
+	err = lockLiveMovie(ctx, tx, movieID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM movie_credits WHERE movie_id = $1`, movieID)
+	if err != nil {
+		return err
+	}
+
+	if len(credits) > 0 {
+		names := make([]string, len(credits))
+		departments := make([]string, len(credits))
+		jobs := make([]string, len(credits))
+		for i, c := range credits {
+			names[i] = c.Name
+			departments[i] = c.Department
+			jobs[i] = c.Job
+		}
+
+		query := `
+		INSERT INTO movie_credits (movie_id, position, name, department, job)
+		SELECT $1, c.position, c.name, c.department, c.job
+		FROM unnest($2::text[], $3::text[], $4::text[]) WITH ORDINALITY AS c(name, department, job, position)`
+
+		_, err = tx.ExecContext(ctx, query, movieID, pq.Array(names), pq.Array(departments), pq.Array(jobs))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Names returns every credited name by movie for the similar movies model, trashed
+// movies are left out
+func (m CreditModel) Names() (map[int64][]string, error) {
+	query := `
+	SELECT movie_credits.movie_id, movie_credits.name
+	FROM movie_credits
+	INNER JOIN movies ON movies.id = movie_credits.movie_id
+	WHERE movies.deleted_at IS NULL
+	ORDER BY movie_credits.movie_id, movie_credits.position`
+
+	//a full table read gets longer than the usual timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byMovie := make(map[int64][]string)
+	for rows.Next() {
+		var movieID int64
+		var name string
+		err := rows.Scan(&movieID, &name)
+		if err != nil {
+			return nil, err
+		}
+		byMovie[movieID] = append(byMovie[movieID], name)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return byMovie, nil
+}
@@ -0,0 +1,61 @@
+package data
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor marks where a keyset page ended: the sort param in use and the last rows
+// value for each ORDER BY column, id tie-breaker included. Clients only ever see
+// it signed and base64 encoded so they cant hand us a made up position
+type Cursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+}
+
+// Encode returns the opaque token, payload.signature both base64url
+func (c Cursor) Encode(secret []byte) string {
+	payload, _ := json.Marshal(c)
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(signCursor(payload, secret))
+}
+
+// DecodeCursor checks the signature on a token from Encode and unpacks it
+func DecodeCursor(token string, secret []byte) (*Cursor, error) {
+	payloadPart, sigPart, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	enc := base64.RawURLEncoding
+	payload, err := enc.DecodeString(payloadPart)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	sig, err := enc.DecodeString(sigPart)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	//constant time compare so the signature cant be guessed byte by byte
+	if !hmac.Equal(sig, signCursor(payload, secret)) {
+		return nil, ErrInvalidCursor
+	}
+
+	var c Cursor
+	err = json.Unmarshal(payload, &c)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+func signCursor(payload, secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,93 @@
+package data
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"regexp"
+
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// Both of the types here are plain string maps stored in jsonb columns,
+// so they share the Value/Scan logic below
+
+// Certifications maps a ISO 3166 country code to its age rating, like {"US": "PG-13"}
+type Certifications map[string]string
+
+// ExternalIDs maps a source like "imdb" to the movies ID over there
+type ExternalIDs map[string]string
+
+var (
+	CountryCodeRX  = regexp.MustCompile(`^[A-Z]{2}$`)
+	LanguageCodeRX = regexp.MustCompile(`^[a-z]{2}$`)
+)
+
+// each supported external source and the format its IDs must have
+var externalIDFormats = map[string]*regexp.Regexp{
+	"imdb":       regexp.MustCompile(`^tt[0-9]{7,8}$`),
+	"tmdb":       regexp.MustCompile(`^[1-9][0-9]*$`),
+	"wikidata":   regexp.MustCompile(`^Q[1-9][0-9]*$`),
+	"letterboxd": regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`),
+}
+
+// ValidateExternalID checks source is one we know about and id is in its format
+func ValidateExternalID(v *validator.Validator, key, source, id string) {
+	rx, ok := externalIDFormats[source]
+	if !ok {
+		v.AddError(key, "unknown external ID source "+source)
+		return
+	}
+	v.Check(validator.Matches(id, rx), key, "invalid "+source+" ID format")
+}
+
+func (c Certifications) Value() (driver.Value, error) {
+	return stringMapValue(c)
+}
+
+func (c *Certifications) Scan(src interface{}) error {
+	return scanStringMap(src, (*map[string]string)(c))
+}
+
+func (e ExternalIDs) Value() (driver.Value, error) {
+	return stringMapValue(e)
+}
+
+func (e *ExternalIDs) Scan(src interface{}) error {
+	return scanStringMap(src, (*map[string]string)(e))
+}
+
+// nil maps still go in as '{}' so the NOT NULL columns are happy
+func stringMapValue(m map[string]string) (driver.Value, error) {
+	if m == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(m)
+}
+
+// pq hands jsonb back as []byte
+func scanStringMap(src interface{}, dst *map[string]string) error {
+	var b []byte
+	switch src := src.(type) {
+	case []byte:
+		b = src
+	case string:
+		b = []byte(src)
+	case nil:
+		*dst = nil
+		return nil
+	default:
+		return errors.New("unsupported type for jsonb string map")
+	}
+	var m map[string]string
+	err := json.Unmarshal(b, &m)
+	if err != nil {
+		return err
+	}
+	//keep empty objects out of the JSON output
+	if len(m) == 0 {
+		m = nil
+	}
+	*dst = m
+	return nil
+}
@@ -0,0 +1,143 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// runtimeBucketBounds splits runtimes into under 90, 90-119, 120-149 and 150+ mins
+var runtimeBucketBounds = []int{90, 120, 150}
+
+// Facets are counts for the filter sidebar on GET /v1/movies. Each facet ignores
+// its own filter so picking one genre still shows the counts for the others
+type Facets struct {
+	Genres   []GenreFacet   `json:"genres"`
+	Decades  []DecadeFacet  `json:"decades"`
+	Runtimes []RuntimeFacet `json:"runtimes"`
+}
+
+type GenreFacet struct {
+	Genre string `json:"genre"`
+	Count int    `json:"count"`
+}
+
+type DecadeFacet struct {
+	Decade int `json:"decade"` //first year, like 1990
+	Count  int `json:"count"`
+}
+
+// RuntimeFacet bounds line up with the runtime_min/runtime_max params, Max is
+// left out for the open ended last bucket
+type RuntimeFacet struct {
+	Min   int  `json:"min"`
+	Max   *int `json:"max,omitempty"`
+	Count int  `json:"count"`
+}
+
+// Facets counts the movies matching filter by genre, decade and runtime bucket
+func (m MovieModel) Facets(filter MovieFilter) (*Facets, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	facets := &Facets{
+		Genres:   []GenreFacet{},
+		Decades:  []DecadeFacet{},
+		Runtimes: []RuntimeFacet{},
+	}
+
+	//genres
+	f := filter
+	f.Genres, f.ExcludeGenres = nil, nil
+	where := f.where()
+	rows, err := m.DB.QueryContext(ctx, `
+	SELECT genre, count(*)
+	FROM movies, unnest(genres) AS genre
+	WHERE `+where.String()+`
+	GROUP BY genre
+	ORDER BY count(*) DESC, genre`, where.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var g GenreFacet
+		err := rows.Scan(&g.Genre, &g.Count)
+		if err != nil {
+			return nil, err
+		}
+		facets.Genres = append(facets.Genres, g)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	//decades
+	f = filter
+	f.YearMin, f.YearMax = nil, nil
+	where = f.where()
+	rows, err = m.DB.QueryContext(ctx, `
+	SELECT year / 10 * 10 AS decade, count(*)
+	FROM movies
+	WHERE `+where.String()+`
+	GROUP BY decade
+	ORDER BY decade`, where.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d DecadeFacet
+		err := rows.Scan(&d.Decade, &d.Count)
+		if err != nil {
+			return nil, err
+		}
+		facets.Decades = append(facets.Decades, d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	//runtime buckets, width_bucket gives 0 below the first bound up to len(bounds) past the last
+	f = filter
+	f.RuntimeMin, f.RuntimeMax = nil, nil
+	where = f.where()
+	bounds := where.placeholder(pq.Array(runtimeBucketBounds))
+	rows, err = m.DB.QueryContext(ctx, fmt.Sprintf(`
+	SELECT width_bucket(runtime, %s::int[]) AS bucket, count(*)
+	FROM movies
+	WHERE %s
+	GROUP BY bucket`, bounds, where), where.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := make([]int, len(runtimeBucketBounds)+1)
+	for rows.Next() {
+		var bucket, count int
+		err := rows.Scan(&bucket, &count)
+		if err != nil {
+			return nil, err
+		}
+		counts[bucket] = count
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	//every bucket is listed, empty ones too
+	for i, count := range counts {
+		bucket := RuntimeFacet{Count: count}
+		if i > 0 {
+			bucket.Min = runtimeBucketBounds[i-1]
+		}
+		if i < len(runtimeBucketBounds) {
+			max := runtimeBucketBounds[i] - 1
+			bucket.Max = &max
+		}
+		facets.Runtimes = append(facets.Runtimes, bucket)
+	}
+
+	return facets, nil
+}
@@ -12,15 +12,23 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	//keyset pagination, opt in. Cursor is nil on the first page
+	UseCursor bool
+	Cursor    *Cursor
+	//total_records costs a full count, so clients can turn it off
+	WithTotal bool
 }
 
 // Define a new Metadata struct for holding the pagination metadata.
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	//Next is where the following keyset page starts, the handler signs it into NextCursor
+	Next *Cursor `json:"-"`
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
@@ -30,31 +38,116 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be a max of 100")
 
-	// check sort param matches value in our safelist
-	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
-}
+	// sort can be several comma separated keys like "-year,title", each one must
+	// be in our safelist and a column can only be used once
+	keys := f.sortKeys()
+	v.Check(len(keys) <= 5, "sort", "must not have more than 5 keys")
+	columns := make([]string, len(keys))
+	for i, key := range keys {
+		v.Check(validator.In(key, f.SortSafelist...), "sort", "invalid sort value")
+		columns[i] = strings.TrimPrefix(key, "-")
+	}
+	v.Check(validator.Unique(columns), "sort", "must not use a column more than once")
 
-// This func checks the client provided sort field matches a entry
-// if it does, extract column name from sot field with stripping
-func (f Filters) sortColumn() string {
-	for _, safeValue := range f.SortSafelist {
-		if f.Sort == safeValue {
-			return strings.TrimPrefix(f.Sort, "-")
+	if f.UseCursor {
+		v.Check(f.Page == 1, "page", "cannot be combined with cursor")
+		if f.Cursor != nil {
+			v.Check(f.Cursor.Sort == f.Sort, "cursor", "was created for a different sort value")
+			//one value per ORDER BY column, thats the keys plus id unless id is a key
+			want := len(columns) + 1
+			if validator.In("id", columns...) {
+				want = len(columns)
+			}
+			v.Check(len(f.Cursor.Values) == want, "cursor", "invalid cursor")
 		}
 	}
-	panic("unsafe sort parameter: " + f.Sort)
+}
+
+// sortKeys splits the sort param on commas
+func (f Filters) sortKeys() []string {
+	keys := strings.Split(f.Sort, ",")
+	for i := range keys {
+		keys[i] = strings.TrimSpace(keys[i])
+	}
+	return keys
+}
 
+// sortColumn is one part of an ORDER BY
+type sortColumn struct {
+	name string
+	desc bool
 }
 
-// below is how we sort our API results
-// return sort direction ASC / DESC depending on prefix char of sort field
-func (f Filters) sortDirection() string {
-	if strings.HasPrefix(f.Sort, "-") {
+func (c sortColumn) direction() string {
+	if c.desc {
 		return "DESC"
 	}
 	return "ASC"
 }
 
+// This func checks every client provided sort key matches a entry in the safelist,
+// if it does, extract column name by stripping the - prefix, which means DESC.
+// The id tie-breaker goes on the end unless id is already one of the keys
+func (f Filters) sortColumns() []sortColumn {
+	var columns []sortColumn
+	hasID := false
+	for _, key := range f.sortKeys() {
+		if !validator.In(key, f.SortSafelist...) {
+			panic("unsafe sort parameter: " + key)
+		}
+		name := strings.TrimPrefix(key, "-")
+		hasID = hasID || name == "id"
+		columns = append(columns, sortColumn{name: name, desc: strings.HasPrefix(key, "-")})
+	}
+	if !hasID {
+		columns = append(columns, sortColumn{name: "id"})
+	}
+	return columns
+}
+
+// orderBy is the body of the ORDER BY clause, like "year DESC, title ASC, id ASC"
+func (f Filters) orderBy() string {
+	var parts []string
+	for _, c := range f.sortColumns() {
+		parts = append(parts, c.name+" "+c.direction())
+	}
+	return strings.Join(parts, ", ")
+}
+
+// keysetCondition adds the condition for rows that come after f.Cursor in orderBy()
+// order. For columns a, b it is (a > x) OR (a = x AND b > y), with < for DESC columns
+func (f Filters) keysetCondition(w *whereBuilder) {
+	columns := f.sortColumns()
+	values := make([]string, len(columns))
+	for i := range columns {
+		values[i] = w.placeholder(f.Cursor.Values[i])
+	}
+
+	var ors []string
+	for i, c := range columns {
+		var ands []string
+		for j := 0; j < i; j++ {
+			ands = append(ands, columns[j].name+" = "+values[j])
+		}
+		op := ">"
+		if c.desc {
+			op = "<"
+		}
+		ands = append(ands, c.name+" "+op+" "+values[i])
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	w.add(strings.Join(ors, " OR "))
+}
+
+// nextCursor builds the cursor after a row, value returns that rows value for a column
+func (f Filters) nextCursor(value func(column string) string) *Cursor {
+	c := &Cursor{Sort: f.Sort}
+	for _, column := range f.sortColumns() {
+		c.Values = append(c.Values, value(column.name))
+	}
+	return c
+}
+
 func (f Filters) limit() int {
 	return f.PageSize
 }
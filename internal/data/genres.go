@@ -0,0 +1,137 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// genres are a managed list now, movies only hold the slugs
+type Genre struct {
+	ID         int64  `json:"-"`
+	Slug       string `json:"slug"`
+	Name       string `json:"name"`
+	MovieCount int    `json:"movie_count"`
+}
+
+// used to collapse any run of whitespace into a single hyphen
+var genreSpaceRX = regexp.MustCompile(`\s+`)
+
+// genreAliases maps other spellings people use onto the canonical slug, keys are
+// already normalized. Migration 000007 cleaned up existing movies with the same list
+var genreAliases = map[string]string{
+	"science-fiction": "sci-fi",
+	"scifi":           "sci-fi",
+	"sf":              "sci-fi",
+	"rom-com":         "romance",
+	"romcom":          "romance",
+	"romantic":        "romance",
+	"animated":        "animation",
+	"cartoon":         "animation",
+	"documentaries":   "documentary",
+	"doc":             "documentary",
+	"biopic":          "biography",
+	"historical":      "history",
+	"sports":          "sport",
+	"thrillers":       "thriller",
+	"musicals":        "musical",
+}
+
+// NormalizeGenre lowercases and trims a genre, turns spaces into hyphens and maps
+// known aliases, so "Sci Fi", "Science Fiction" and "sci-fi" are all the same slug
+func NormalizeGenre(genre string) string {
+	slug := genreSpaceRX.ReplaceAllString(strings.ToLower(strings.TrimSpace(genre)), "-")
+	if canonical, ok := genreAliases[slug]; ok {
+		return canonical
+	}
+	return slug
+}
+
+// NormalizeGenres runs NormalizeGenre over a slice, nil stays nil so
+// ValidateMovie can still tell the field was not provided. Two spellings of
+// the same genre only count once
+func NormalizeGenres(genres []string) []string {
+	if genres == nil {
+		return nil
+	}
+	normalized := make([]string, 0, len(genres))
+	seen := make(map[string]bool, len(genres))
+	for _, genre := range genres {
+		slug := NormalizeGenre(genre)
+		if !seen[slug] {
+			seen[slug] = true
+			normalized = append(normalized, slug)
+		}
+	}
+	return normalized
+}
+
+type GenreModel struct {
+	DB *sql.DB
+}
+
+// GetAll returns every genre along with how many movies use it
+func (m GenreModel) GetAll() ([]*Genre, error) {
+	query := `
+	SELECT genres.id, genres.slug, genres.name, count(movies.id)
+	FROM genres
+	LEFT JOIN movies ON movies.genres @> ARRAY[genres.slug] AND movies.deleted_at IS NULL
+	GROUP BY genres.id
+	ORDER BY genres.name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []*Genre{}
+	for rows.Next() {
+		var genre Genre
+		err := rows.Scan(&genre.ID, &genre.Slug, &genre.Name, &genre.MovieCount)
+		if err != nil {
+			return nil, err
+		}
+		genres = append(genres, &genre)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return genres, nil
+}
+
+// Slugs returns just the known genre slugs, this is what ValidateMovie checks against
+func (m GenreModel) Slugs() ([]string, error) {
+	query := `
+	SELECT slug
+	FROM genres
+	ORDER BY slug`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var slugs []string
+	for rows.Next() {
+		var slug string
+		err := rows.Scan(&slug)
+		if err != nil {
+			return nil, err
+		}
+		slugs = append(slugs, slug)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return slugs, nil
+}
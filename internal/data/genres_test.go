@@ -0,0 +1,41 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeGenre(t *testing.T) {
+	tests := map[string]string{
+		"Drama":               "drama",
+		"  Sci Fi ":           "sci-fi",
+		"Science Fiction":     "sci-fi",
+		"science\tfiction":    "sci-fi",
+		"SF":                  "sci-fi",
+		"Rom Com":             "romance",
+		"Sports":              "sport",
+		"film noir":           "film-noir",
+		"Science Fiction-ish": "science-fiction-ish",
+	}
+	for in, want := range tests {
+		if got := NormalizeGenre(in); got != want {
+			t.Errorf("NormalizeGenre(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestNormalizeGenres(t *testing.T) {
+	tests := []struct {
+		in, want []string
+	}{
+		{nil, nil},
+		{[]string{}, []string{}},
+		{[]string{"Science Fiction", "Horror", "sci-fi"}, []string{"sci-fi", "horror"}},
+		{[]string{"drama", "Drama"}, []string{"drama"}},
+	}
+	for _, tt := range tests {
+		if got := NormalizeGenres(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("NormalizeGenres(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
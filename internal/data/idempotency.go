@@ -0,0 +1,120 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// IdempotencyKey is a stored POST response, see the idempotency middleware
+type IdempotencyKey struct {
+	UserID      int64
+	Key         string
+	RequestHash []byte //method, path and body, so the key cant be reused for a different request
+	StatusCode  int    //0 while the first request is still running
+	Headers     map[string][]string
+	Body        []byte
+	ExpiresAt   time.Time
+}
+
+type IdempotencyModel struct {
+	DB *sql.DB
+}
+
+// Reserve claims key for a new request. It returns false if the key is already
+// taken, expired keys are cleared out first so they can be used again
+func (m IdempotencyModel) Reserve(key *IdempotencyKey) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `
+	DELETE FROM idempotency_keys
+	WHERE user_id = $1 AND key = $2 AND expires_at < NOW()`, key.UserID, key.Key)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := m.DB.ExecContext(ctx, `
+	INSERT INTO idempotency_keys (user_id, key, request_hash, expires_at)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (user_id, key) DO NOTHING`, key.UserID, key.Key, key.RequestHash, key.ExpiresAt)
+	if err != nil {
+		return false, err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return inserted == 1, nil
+}
+
+// Get returns a stored key, ErrRecordNotFound if it doesnt exist
+func (m IdempotencyModel) Get(userID int64, key string) (*IdempotencyKey, error) {
+	query := `
+	SELECT request_hash, COALESCE(status_code, 0), response_headers, response_body, expires_at
+	FROM idempotency_keys
+	WHERE user_id = $1 AND key = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	k := IdempotencyKey{UserID: userID, Key: key}
+	var headers []byte
+	err := m.DB.QueryRowContext(ctx, query, userID, key).Scan(&k.RequestHash, &k.StatusCode, &headers, &k.Body, &k.ExpiresAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	err = json.Unmarshal(headers, &k.Headers)
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// Complete saves the response for a reserved key
+func (m IdempotencyModel) Complete(key *IdempotencyKey) error {
+	headers, err := json.Marshal(key.Headers)
+	if err != nil {
+		return err
+	}
+	query := `
+	UPDATE idempotency_keys
+	SET status_code = $1, response_headers = $2, response_body = $3
+	WHERE user_id = $4 AND key = $5`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, key.StatusCode, headers, key.Body, key.UserID, key.Key)
+	return err
+}
+
+// Release drops a reserved key so the request can be tried again from scratch
+func (m IdempotencyModel) Release(userID int64, key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `
+	DELETE FROM idempotency_keys
+	WHERE user_id = $1 AND key = $2`, userID, key)
+	return err
+}
+
+// DeleteExpired clears out old keys, returning how many went
+func (m IdempotencyModel) DeleteExpired() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at < NOW()")
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
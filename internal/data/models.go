@@ -14,18 +14,38 @@ var (
 
 // models struct to wrap moviemodel -
 type Models struct {
-	Movies      MovieModel
-	Permissions PermissionModel //added for avail to handlers and middleware
-	Tokens      TokenModel
-	Users       UserModel
+	Audit        AuditModel
+	Credits      CreditModel
+	Genres       GenreModel
+	Idempotency  IdempotencyModel
+	Movies       MovieModel
+	Permissions  PermissionModel //added for avail to handlers and middleware
+	Ratings      RatingModel
+	ReleaseDates ReleaseDateModel
+	Revisions    RevisionModel
+	Search       SearchModel
+	Series       SeriesModel
+	Tokens       TokenModel
+	Users        UserModel
+	Watchlist    WatchlistModel
 }
 
 // this method below returns models struct with init movieModel
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Permissions: PermissionModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Users:       UserModel{DB: db},
+		Audit:        AuditModel{DB: db},
+		Credits:      CreditModel{DB: db},
+		Genres:       GenreModel{DB: db},
+		Idempotency:  IdempotencyModel{DB: db},
+		Movies:       MovieModel{DB: db},
+		Permissions:  PermissionModel{DB: db},
+		Ratings:      RatingModel{DB: db},
+		ReleaseDates: ReleaseDateModel{DB: db},
+		Revisions:    RevisionModel{DB: db},
+		Search:       SearchModel{DB: db},
+		Series:       SeriesModel{DB: db},
+		Tokens:       TokenModel{DB: db},
+		Users:        UserModel{DB: db},
+		Watchlist:    WatchlistModel{DB: db},
 	} //Done to help later on
 }
@@ -0,0 +1,110 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// a whole batch gets longer than a single query, each statement still has sql_timeout
+const movieBatchTimeout = time.Minute
+
+// MovieBatch runs many movie writes in one transaction. Each operation goes through
+// Step so a failed one can be undone on its own without losing the rest
+type MovieBatch struct {
+	tx     *sql.Tx
+	cancel context.CancelFunc
+}
+
+func (m MovieModel) BeginBatch() (*MovieBatch, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), movieBatchTimeout)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	err = setActor(ctx, tx, m.actor)
+	if err != nil {
+		tx.Rollback()
+		cancel()
+		return nil, err
+	}
+	return &MovieBatch{tx: tx, cancel: cancel}, nil
+}
+
+// Step runs fn inside a savepoint. If fn errors its writes are rolled back and the
+// batch carries on, the error is returned as is
+func (b *MovieBatch) Step(fn func() error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	_, err := b.tx.ExecContext(ctx, "SAVEPOINT batch_step")
+	if err != nil {
+		return err
+	}
+	stepErr := fn()
+	if stepErr != nil {
+		_, err = b.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT batch_step")
+	} else {
+		_, err = b.tx.ExecContext(ctx, "RELEASE SAVEPOINT batch_step")
+	}
+	if err != nil {
+		return err
+	}
+	return stepErr
+}
+
+// Get fetches a movie and locks it until the batch ends
+func (b *MovieBatch) Get(id int64) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+	SELECT ` + movieColumns + `
+	FROM movies
+	WHERE id = $1 AND deleted_at IS NULL
+	FOR UPDATE`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	var movie Movie
+	err := b.tx.QueryRowContext(ctx, query, id).Scan(movie.scanDest()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &movie, nil
+}
+
+func (b *MovieBatch) Insert(movie *Movie) error {
+	return insertMovie(b.tx, movie)
+}
+
+func (b *MovieBatch) Update(movie *Movie) error {
+	return updateMovie(b.tx, movie)
+}
+
+func (b *MovieBatch) Delete(id int64) error {
+	return deleteMovie(b.tx, id)
+}
+
+func (b *MovieBatch) Commit() error {
+	defer b.cancel()
+	return b.tx.Commit()
+}
+
+// Rollback undoes the whole batch, safe to call after Commit
+func (b *MovieBatch) Rollback() error {
+	defer b.cancel()
+	err := b.tx.Rollback()
+	if errors.Is(err, sql.ErrTxDone) {
+		return nil
+	}
+	return err
+}
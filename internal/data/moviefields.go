@@ -0,0 +1,109 @@
+package data
+
+import (
+	"strings"
+
+	"github.com/lib/pq"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// movieField ties a Movie JSON field to its column and scan target
+type movieField struct {
+	name   string
+	column string
+	dest   func(movie *Movie) interface{}
+}
+
+// movieFields is every movie column in select order. created_at has no JSON name
+// so it only gets selected when the whole movie is asked for.
+// Use pq.Array for the array columns or Scan errors at runtime
+var movieFields = []movieField{
+	{"id", "movies.id", func(m *Movie) interface{} { return &m.ID }},
+	{"", "movies.created_at", func(m *Movie) interface{} { return &m.CreatedAt }},
+	{"title", "movies.title", func(m *Movie) interface{} { return &m.Title }},
+	{"year", "movies.year", func(m *Movie) interface{} { return &m.Year }},
+	{"runtime", "movies.runtime", func(m *Movie) interface{} { return &m.Runtime }},
+	{"genres", "movies.genres", func(m *Movie) interface{} { return pq.Array(&m.Genres) }},
+	{"version", "movies.version", func(m *Movie) interface{} { return &m.Version }},
+	{"synopsis", "movies.synopsis", func(m *Movie) interface{} { return &m.Synopsis }},
+	{"original_title", "movies.original_title", func(m *Movie) interface{} { return &m.OriginalTitle }},
+	{"original_language", "movies.original_language", func(m *Movie) interface{} { return &m.OriginalLanguage }},
+	{"spoken_languages", "movies.spoken_languages", func(m *Movie) interface{} { return pq.Array(&m.SpokenLanguages) }},
+	{"certifications", "movies.certifications", func(m *Movie) interface{} { return &m.Certifications }},
+	{"external_ids", "movies.external_ids", func(m *Movie) interface{} { return &m.ExternalIDs }},
+	{"poster_url", "movies.poster_url", func(m *Movie) interface{} { return &m.PosterURL }},
+	{"poster_thumbnail_url", "movies.poster_thumbnail_url", func(m *Movie) interface{} { return &m.PosterThumbnailURL }},
+}
+
+// MovieIncludes are the related resources ?include= can embed in a movie,
+// they arent columns so the handlers load them separately
+var MovieIncludes = []string{"release_dates", "series", "credits", "rating"}
+
+// MovieDefaultIncludes are embedded in a single movie when ?include= isnt given
+var MovieDefaultIncludes = []string{"release_dates", "series"}
+
+// MovieFieldNames lists the JSON names ?fields= can pick from
+func MovieFieldNames() []string {
+	var names []string
+	for _, f := range movieFields {
+		if f.name != "" {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+// ValidateMovieFields checks the ?fields= and ?include= lists
+func ValidateMovieFields(v *validator.Validator, fields, include []string) {
+	names := MovieFieldNames()
+	for _, field := range fields {
+		if !validator.In(field, names...) {
+			v.AddError("fields", "unknown field: "+field)
+			break
+		}
+	}
+	v.Check(validator.Unique(fields), "fields", "must not contain duplicate values")
+
+	for _, resource := range include {
+		if !validator.In(resource, MovieIncludes...) {
+			v.AddError("include", "unknown resource: "+resource+", must be one of "+strings.Join(MovieIncludes, ", "))
+			break
+		}
+	}
+	v.Check(validator.Unique(include), "include", "must not contain duplicate values")
+}
+
+// movieSelection is the columns a query selects
+type movieSelection []movieField
+
+// selectMovieFields picks the columns for the JSON names in fields, id always
+// comes along. No fields means every column
+func selectMovieFields(fields []string) movieSelection {
+	if len(fields) == 0 {
+		return movieFields
+	}
+	var s movieSelection
+	for _, f := range movieFields {
+		if f.name == "id" || (f.name != "" && validator.In(f.name, fields...)) {
+			s = append(s, f)
+		}
+	}
+	return s
+}
+
+func (s movieSelection) columns() string {
+	columns := make([]string, len(s))
+	for i, f := range s {
+		columns[i] = f.column
+	}
+	return strings.Join(columns, ", ")
+}
+
+// dest returns the scan targets for columns() in the same order
+func (s movieSelection) dest(movie *Movie) []interface{} {
+	dest := make([]interface{}, len(s))
+	for i, f := range s {
+		dest[i] = f.dest(movie)
+	}
+	return dest
+}
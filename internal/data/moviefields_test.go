@@ -0,0 +1,55 @@
+package data
+
+import (
+	"encoding/json"
+	"testing"
+
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+func TestValidateMovieFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  []string
+		include []string
+		valid   bool
+	}{
+		{"credits and rating", nil, []string{"credits", "rating"}, true},
+		{"with fields", []string{"title", "year"}, []string{"credits", "rating"}, true},
+		{"everything", nil, MovieIncludes, true},
+		{"unknown include", nil, []string{"credits", "cast"}, false},
+		{"duplicate include", nil, []string{"rating", "rating"}, false},
+		{"unknown field", []string{"rating"}, nil, false},
+	}
+	for _, tt := range tests {
+		v := validator.New()
+		ValidateMovieFields(v, tt.fields, tt.include)
+		if v.Valid() != tt.valid {
+			t.Errorf("%s: valid is %t, want %t: %v", tt.name, v.Valid(), tt.valid, v.Errors)
+		}
+	}
+}
+
+// ?fields= projection keeps included resources by name, so every include has to
+// be the JSON key it ends up under
+func TestMovieIncludesAreJSONKeys(t *testing.T) {
+	movie := &Movie{
+		ReleaseDates: []ReleaseDate{{Region: "GB"}},
+		Series:       []*MovieSeries{{}},
+		Credits:      []Credit{{Name: "Ingrid Bergman", Department: CreditCast}},
+		Rating:       &RatingSummary{Average: 8.5, Count: 2},
+	}
+	js, err := json.Marshal(movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(js, &keys); err != nil {
+		t.Fatal(err)
+	}
+	for _, include := range MovieIncludes {
+		if _, ok := keys[include]; !ok {
+			t.Errorf("include %q isnt a key in %s", include, js)
+		}
+	}
+}
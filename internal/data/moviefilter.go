@@ -0,0 +1,103 @@
+package data
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// how the genres filter matches
+const (
+	GenresModeAll = "all" //movie has every listed genre
+	GenresModeAny = "any" //movie has at least one
+)
+
+// MovieFilter is everything GET /v1/movies can narrow results by.
+// nil pointers and empty values mean no filter
+type MovieFilter struct {
+	Title         string
+	Fuzzy         bool //match title by trigram similarity so typos still hit
+	Genres        []string
+	GenresMode    string
+	ExcludeGenres []string
+	YearMin       *int
+	YearMax       *int
+	RuntimeMin    *int
+	RuntimeMax    *int
+	CreatedAfter  *time.Time
+
+	//best fuzzy title match first, ahead of the sort. Set when the client didnt
+	//pick a sort, keyset pages cant follow it
+	RankBySimilarity bool
+}
+
+func ValidateMovieFilter(v *validator.Validator, f MovieFilter) {
+	v.Check(!f.Fuzzy || f.Title != "", "fuzzy", "needs a title to match")
+	v.Check(validator.In(f.GenresMode, GenresModeAll, GenresModeAny), "genres_mode", "must be all or any")
+
+	if f.YearMin != nil {
+		v.Check(*f.YearMin >= 1888, "year_min", "must be at least 1888")
+	}
+	if f.YearMax != nil {
+		v.Check(*f.YearMax >= 1888, "year_max", "must be at least 1888")
+	}
+	if f.YearMin != nil && f.YearMax != nil {
+		v.Check(*f.YearMin <= *f.YearMax, "year_min", "must not be greater than year_max")
+	}
+
+	if f.RuntimeMin != nil {
+		v.Check(*f.RuntimeMin >= 0, "runtime_min", "must not be negative")
+	}
+	if f.RuntimeMax != nil {
+		v.Check(*f.RuntimeMax >= 0, "runtime_max", "must not be negative")
+	}
+	if f.RuntimeMin != nil && f.RuntimeMax != nil {
+		v.Check(*f.RuntimeMin <= *f.RuntimeMax, "runtime_min", "must not be greater than runtime_max")
+	}
+
+	if f.CreatedAfter != nil {
+		v.Check(f.CreatedAfter.Before(time.Now()), "created_after", "must be in the past")
+	}
+}
+
+// where turns the filter into SQL conditions on the movies table, movies in the
+// trash are always left out
+func (f MovieFilter) where() *whereBuilder {
+	w := &whereBuilder{}
+	w.add("deleted_at IS NULL")
+
+	switch {
+	case f.Title != "" && f.Fuzzy:
+		w.add("? <% title", f.Title)
+	case f.Title != "":
+		w.add("to_tsvector('simple', title) @@ plainto_tsquery('simple', ?)", f.Title)
+	}
+	if len(f.Genres) > 0 {
+		switch f.GenresMode {
+		case GenresModeAny:
+			w.add("genres && ?", pq.Array(f.Genres))
+		default:
+			w.add("genres @> ?", pq.Array(f.Genres))
+		}
+	}
+	if len(f.ExcludeGenres) > 0 {
+		w.add("NOT genres && ?", pq.Array(f.ExcludeGenres))
+	}
+	if f.YearMin != nil {
+		w.add("year >= ?", *f.YearMin)
+	}
+	if f.YearMax != nil {
+		w.add("year <= ?", *f.YearMax)
+	}
+	if f.RuntimeMin != nil {
+		w.add("runtime >= ?", *f.RuntimeMin)
+	}
+	if f.RuntimeMax != nil {
+		w.add("runtime <= ?", *f.RuntimeMax)
+	}
+	if f.CreatedAfter != nil {
+		w.add("created_at > ?", *f.CreatedAfter)
+	}
+	return w
+}
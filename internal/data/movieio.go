@@ -0,0 +1,174 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// exports and imports walk the whole catalogue so they get a lot longer than sql_timeout
+const (
+	movieExportTimeout = 10 * time.Minute
+	movieImportTimeout = 10 * time.Minute
+	movieExportBatch   = 500 //rows per FETCH
+)
+
+// Export calls fn for every movie matching filter in id order. Rows come from a
+// server side cursor a batch at a time, so the catalogue is never all in memory.
+// An error from fn stops the export and is returned
+func (m MovieModel) Export(filter MovieFilter, fn func(movie *Movie) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), movieExportTimeout)
+	defer cancel()
+
+	//cursors only live inside a transaction, read only is all we need
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	where := filter.where()
+	_, err = tx.ExecContext(ctx, `
+	DECLARE movie_export NO SCROLL CURSOR FOR
+	SELECT `+movieColumns+`
+	FROM movies
+	WHERE `+where.String()+`
+	ORDER BY id`, where.args...)
+	if err != nil {
+		return err
+	}
+
+	for {
+		//FETCH takes no parameters, the count has to be in the SQL
+		rows, err := tx.QueryContext(ctx, fmt.Sprintf("FETCH %d FROM movie_export", movieExportBatch))
+		if err != nil {
+			return err
+		}
+		fetched := 0
+		for rows.Next() {
+			fetched++
+			var movie Movie
+			err = rows.Scan(movie.scanDest()...)
+			if err == nil {
+				err = fn(&movie)
+			}
+			if err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		if fetched < movieExportBatch {
+			return nil
+		}
+	}
+}
+
+// MovieImport bulk inserts movies with COPY inside one transaction. Add validated
+// movies then Commit, nothing is visible until then
+type MovieImport struct {
+	tx     *sql.Tx
+	stmt   *sql.Stmt
+	cancel context.CancelFunc
+	count  int
+}
+
+// the columns an import fills in, the rest get their defaults
+var movieImportColumns = []string{
+	"title", "year", "runtime", "genres", "synopsis", "original_title",
+	"original_language", "spoken_languages", "certifications", "external_ids",
+}
+
+func (m MovieModel) BeginImport() (*MovieImport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), movieImportTimeout)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	err = setActor(ctx, tx, m.actor)
+	if err != nil {
+		tx.Rollback()
+		cancel()
+		return nil, err
+	}
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("movies", movieImportColumns...))
+	if err != nil {
+		tx.Rollback()
+		cancel()
+		return nil, err
+	}
+	return &MovieImport{tx: tx, stmt: stmt, cancel: cancel}, nil
+}
+
+// Add queues a movie for the COPY. Run ValidateMovie first, a bad row fails the
+// whole COPY when it is flushed
+func (i *MovieImport) Add(movie *Movie) error {
+	//COPY sends []byte as bytea and a nil array as NULL, so jsonb goes as a
+	//string and arrays are never nil
+	certifications, err := movie.Certifications.Value()
+	if err != nil {
+		return err
+	}
+	externalIDs, err := movie.ExternalIDs.Value()
+	if err != nil {
+		return err
+	}
+	_, err = i.stmt.Exec(
+		movie.Title,
+		movie.Year,
+		movie.Runtime,
+		pq.Array(nonNil(movie.Genres)),
+		movie.Synopsis,
+		movie.OriginalTitle,
+		movie.OriginalLanguage,
+		pq.Array(nonNil(movie.SpokenLanguages)),
+		string(certifications.([]byte)),
+		string(externalIDs.([]byte)),
+	)
+	if err != nil {
+		return err
+	}
+	i.count++
+	return nil
+}
+
+// Commit flushes the COPY and commits, returning how many movies went in
+func (i *MovieImport) Commit() (int, error) {
+	defer i.cancel()
+	_, err := i.stmt.Exec()
+	if err != nil {
+		i.tx.Rollback()
+		return 0, err
+	}
+	err = i.stmt.Close()
+	if err != nil {
+		i.tx.Rollback()
+		return 0, err
+	}
+	err = i.tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return i.count, nil
+}
+
+// Rollback throws the import away, safe to call after Commit
+func (i *MovieImport) Rollback() {
+	defer i.cancel()
+	i.stmt.Close()
+	i.tx.Rollback()
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -6,6 +6,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/lib/pq"
@@ -23,46 +24,98 @@ type Movie struct {
 	Runtime   Runtime   `json:"runtime,omitempty"` //in Mins, movie length
 	Genres    []string  `json:"genres,omitempty"`  //slice of genres for movie
 	Version   int32     `json:"version"`           // Version number, starting at 1 and incredmented ea time movie info updated
+
+	Synopsis         string         `json:"synopsis,omitempty"`
+	OriginalTitle    string         `json:"original_title,omitempty"`    //title in its original language
+	OriginalLanguage string         `json:"original_language,omitempty"` //ISO 639-1 code, like "en"
+	SpokenLanguages  []string       `json:"spoken_languages,omitempty"`  //ISO 639-1 codes
+	Certifications   Certifications `json:"certifications,omitempty"`    //age rating per country
+	ExternalIDs      ExternalIDs    `json:"external_ids,omitempty"`      //IDs on other sites, like imdb
+
+	PosterURL          string `json:"poster_url,omitempty"` //set by PUT /v1/movies/:id/poster only
+	PosterThumbnailURL string `json:"poster_thumbnail_url,omitempty"`
+
+	ReleaseDates []ReleaseDate  `json:"release_dates,omitempty"` //not a column, filled in from ReleaseDateModel
+	Series       []*MovieSeries `json:"series,omitempty"`        //not a column, filled in from SeriesModel
+	Credits      []Credit       `json:"credits,omitempty"`       //not a column, filled in from CreditModel
+	Rating       *RatingSummary `json:"rating,omitempty"`        //not a column, filled in from RatingModel
+}
+
+// movieColumns is the select list every movie query uses, scanDest() below gives
+// the matching targets. Both come from movieFields in moviefields.go
+var movieColumns = selectMovieFields(nil).columns()
+
+// scanDest returns pointers to the movie fields matching movieColumns
+func (movie *Movie) scanDest() []interface{} {
+	return selectMovieFields(nil).dest(movie)
+}
+
+// querier is what *sql.DB and *sql.Tx have in common, so the same movie
+// queries run on their own or inside a MovieBatch
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
 }
 
 // moviemodel struct to wrap a SQL.db connection pool
+// actor is the user writes get recorded against in movie_revisions, see As()
 type MovieModel struct {
-	DB *sql.DB
+	DB    *sql.DB
+	actor int64
 }
 
 // This method will insert a new record into the movies table
 //accepts a pointer to movie struct, which should have data for new record
 
 func (m MovieModel) Insert(movie *Movie) error {
+	return m.write(func(q querier) error {
+		return insertMovie(q, movie)
+	})
+}
+
+func insertMovie(q querier, movie *Movie) error {
 	// define SQL query for new record
 	query := `
-	INSERT INTO movies (title, year, runtime, genres)
-	VALUES ($1, $2, $3, $4)
+	INSERT INTO movies (title, year, runtime, genres, synopsis, original_title, original_language, spoken_languages, certifications, external_ids)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 	RETURNING id, created_at, version`
 
 	//create slice with values , doing this next to the SQL query
 	//makes it clear
-	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+	args := []interface{}{
+		movie.Title,
+		movie.Year,
+		movie.Runtime,
+		pq.Array(movie.Genres),
+		movie.Synopsis,
+		movie.OriginalTitle,
+		movie.OriginalLanguage,
+		pq.Array(nonNil(movie.SpokenLanguages)),
+		movie.Certifications,
+		movie.ExternalIDs,
+	}
 	//3 sec timeout
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
 	defer cancel()
 	//use QueryRow to exec SQL on connection pool
 	//string gets passes in as variadic parameter
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	return q.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
 } //Insert mutates moviestruct and adds system gen values to it
 
-// This method will fetch a record from the movies table
-func (m MovieModel) Get(id int64) (*Movie, error) {
+// This method will fetch a record from the movies table. fields are JSON names to
+// limit the columns to, see selectMovieFields, leave them out for the whole movie
+func (m MovieModel) Get(id int64, fields ...string) (*Movie, error) {
 	//Define SQL query for GET
 	if id < 1 { //This is to align ourselves with postgres as it dosen't have unsigned integers
 		//and to prevent a value more than 92233720365457758....
 		return nil, ErrRecordNotFound
 	}
 	//Added sleep as first value for testing --DELETEME
+	selection := selectMovieFields(fields)
 	query := `
-	SELECT id, created_at, title, year, runtime, genres, version
+	SELECT ` + selection.columns() + `
 	FROM movies
-	WHERE id = $1`
+	WHERE id = $1 AND deleted_at IS NULL`
 	//declare Movie struct to hold the movie data
 	var movie Movie
 
@@ -72,16 +125,7 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	//timeout countdown begins moment context is created in this func
 	//Execute using queryrow, scan response data into fields into
 	//movie struct, use pq.array adapter function
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
-		&movie.ID,
-		&movie.CreatedAt,
-		&movie.Title,
-		&movie.Year,
-		&movie.Runtime,
-		pq.Array(&movie.Genres),
-		&movie.Version,
-	) //if we did not use pq.Array would get an error at runtime
-	//'unsupported Scan...
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(selection.dest(&movie)...)
 
 	//Handle Errors, if no match found scan return sql.errnorows
 	//errs, check for this
@@ -97,13 +141,48 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	return &movie, nil
 }
 
+// GetByExternalID finds the movie carrying the given ID from another source, like imdb
+func (m MovieModel) GetByExternalID(source, externalID string) (*Movie, error) {
+	//@> lets postgres use the GIN index on external_ids
+	query := `
+	SELECT ` + movieColumns + `
+	FROM movies
+	WHERE external_ids @> jsonb_build_object($1::text, $2::text) AND deleted_at IS NULL
+	ORDER BY id
+	LIMIT 1`
+
+	var movie Movie
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, source, externalID).Scan(movie.scanDest()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &movie, nil
+}
+
 // This method will update certian records in movie table
 func (m MovieModel) Update(movie *Movie) error {
+	return m.write(func(q querier) error {
+		return updateMovie(q, movie)
+	})
+}
+
+func updateMovie(q querier, movie *Movie) error {
 	//SQL to update record
 	query := `
 	UPDATE movies
-	SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-	WHERE id = $5 AND version = $6
+	SET title = $1, year = $2, runtime = $3, genres = $4, synopsis = $5, original_title = $6,
+		original_language = $7, spoken_languages = $8, certifications = $9, external_ids = $10,
+		version = version + 1
+	WHERE id = $11 AND version = $12 AND deleted_at IS NULL
 	RETURNING version`
 
 	//args slice to hold values of placeholder params we overwrite later
@@ -112,6 +191,12 @@ func (m MovieModel) Update(movie *Movie) error {
 		movie.Year,
 		movie.Runtime,
 		pq.Array(movie.Genres),
+		movie.Synopsis,
+		movie.OriginalTitle,
+		movie.OriginalLanguage,
+		pq.Array(nonNil(movie.SpokenLanguages)),
+		movie.Certifications,
+		movie.ExternalIDs,
 		movie.ID,
 		movie.Version,
 	}
@@ -119,7 +204,7 @@ func (m MovieModel) Update(movie *Movie) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
 	defer cancel()
 	//queryrow to execute query on arge slice, scan new version into movie struct
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	err := q.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -132,21 +217,86 @@ func (m MovieModel) Update(movie *Movie) error {
 	//mutate in place again, update wiht new version num only
 }
 
-// This method will delete a record from Movies table
+// UpdatePoster saves new poster URLs, same version check as Update()
+func (m MovieModel) UpdatePoster(movie *Movie) error {
+	query := `
+	UPDATE movies
+	SET poster_url = $1, poster_thumbnail_url = $2, version = version + 1
+	WHERE id = $3 AND version = $4 AND deleted_at IS NULL
+	RETURNING version`
+
+	args := []interface{}{movie.PosterURL, movie.PosterThumbnailURL, movie.ID, movie.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	err := m.write(func(q querier) error {
+		return q.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// This method moves a record to the trash, PurgeTrash does the real delete later
 func (m MovieModel) Delete(id int64) error {
+	return m.write(func(q querier) error {
+		return deleteMovie(q, id)
+	})
+}
+
+// DeleteVersion moves a movie to the trash only if it is still at version, ErrEditConflict if not
+func (m MovieModel) DeleteVersion(id int64, version int32) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+	UPDATE movies
+	SET deleted_at = NOW(), version = version + 1
+	WHERE id = $1 AND version = $2 AND deleted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	return m.write(func(q querier) error {
+		result, err := q.ExecContext(ctx, query, id, version)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		//gone or changed, either way the callers version is stale
+		if rowsAffected == 0 {
+			return ErrEditConflict
+		}
+		return nil
+	})
+}
+
+func deleteMovie(q querier, id int64) error {
 	//check, return errrecordnotfound if movie if less than 1
 	if id < 1 {
 		return ErrRecordNotFound
 	}
-	//SQL
+	//soft delete, the row stays in the trash until PurgeTrash gets it.
+	//version goes up so old ETags stop matching
 	query := `
-	DELETE FROM movies
-	WHERE id = $1`
+	UPDATE movies
+	SET deleted_at = NOW(), version = version + 1
+	WHERE id = $1 AND deleted_at IS NULL`
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
 	defer cancel()
 	//exec SQL query
-	result, err := m.DB.ExecContext(ctx, query, id)
+	result, err := q.ExecContext(ctx, query, id)
 	if err != nil {
 		return err
 	}
@@ -166,14 +316,16 @@ func (m MovieModel) Delete(id int64) error {
 }
 
 //Validation checks on the movie STRUCT, not input
+//knownGenres is the slug list from GenreModel.Slugs(), anything else gets rejected
 
-func ValidateMovie(v *validator.Validator, movie *Movie) {
+func ValidateMovie(v *validator.Validator, movie *Movie, knownGenres []string) {
 	v.Check(movie.Title != "", "title", "must be provided")
 	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
 
 	v.Check(movie.Year != 0, "year", "must be provided")
 	v.Check(movie.Year >= 1888, "year", "must be greater than 1888")
-	v.Check(movie.Year <= int32(time.Now().Year()), "year", "must not be in the future")
+	//announced titles can be up to MaxAnnouncedYears ahead
+	v.Check(movie.Year <= int32(time.Now().Year()+MaxAnnouncedYears), "year", fmt.Sprintf("must not be more than %d years in the future", MaxAnnouncedYears))
 
 	v.Check(movie.Runtime != 0, "runtime", "must be provided")
 	v.Check(movie.Runtime > 0, "runtime", "must be a positive integer")
@@ -182,31 +334,91 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
 	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
 	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
+	for _, genre := range movie.Genres {
+		v.Check(validator.In(genre, knownGenres...), "genres", fmt.Sprintf("contains unknown genre %q, see GET /v1/genres", genre))
+	}
+
+	v.Check(len(movie.Synopsis) <= 5000, "synopsis", "must not be more than 5000 bytes long")
+	v.Check(len(movie.OriginalTitle) <= 500, "original_title", "must not be more than 500 bytes long")
+	if movie.OriginalLanguage != "" {
+		v.Check(validator.Matches(movie.OriginalLanguage, LanguageCodeRX), "original_language", "must be a lowercase ISO 639-1 code")
+	}
+
+	v.Check(len(movie.SpokenLanguages) <= 20, "spoken_languages", "must not contain more than 20 languages")
+	v.Check(validator.Unique(movie.SpokenLanguages), "spoken_languages", "must not contain duplicate values")
+	for _, language := range movie.SpokenLanguages {
+		v.Check(validator.Matches(language, LanguageCodeRX), "spoken_languages", "must only contain lowercase ISO 639-1 codes")
+	}
+
+	for country, rating := range movie.Certifications {
+		v.Check(validator.Matches(country, CountryCodeRX), "certifications", "keys must be uppercase ISO 3166-1 country codes")
+		v.Check(rating != "", "certifications", "ratings must not be empty")
+		v.Check(len(rating) <= 20, "certifications", "ratings must not be more than 20 bytes long")
+	}
+
+	for source, id := range movie.ExternalIDs {
+		ValidateExternalID(v, "external_ids", source, id)
+	}
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
-	//SQL query to get all movie records
-	//Has ORDER by in filter.go
-	query := fmt.Sprintf(`
-		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
-		FROM movies
-		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-		AND (genres @> $2 OR $2 = '{}')
-		ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+// GetAll lists movies, fields limits the columns like Get. The sort columns are
+// always selected since the next cursor is built from them
+func (m MovieModel) GetAll(filter MovieFilter, filters Filters, fields ...string) ([]*Movie, Metadata, error) {
+	where := filter.where()
+
+	var selection movieSelection
+	if len(fields) == 0 {
+		selection = selectMovieFields(nil)
+	} else {
+		for _, column := range filters.sortColumns() {
+			fields = append(fields, column.name)
+		}
+		selection = selectMovieFields(fields)
+	}
 
 	//create CTX context with 3s timeout
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
 	defer cancel()
 
-	args := []interface{}{title, pq.Array(genres), filters.limit(), filters.offset()}
+	//the window count makes postgres walk every match, so its only done when asked for
+	countColumn := "0"
+	if filters.WithTotal && !filters.UseCursor {
+		countColumn = "count(*) OVER()"
+	}
+
+	//keyset mode picks up after the cursor row instead of skipping OFFSET rows,
+	//and fetches one extra row to know if theres another page
+	page := where.clone()
+	limit, offset := filters.limit(), filters.offset()
+	if filters.UseCursor {
+		offset = 0
+		limit++
+		if filters.Cursor != nil {
+			filters.keysetCondition(page)
+		}
+	}
+
+	limitArg, offsetArg := page.placeholder(limit), page.placeholder(offset)
+	orderBy := filters.orderBy()
+	if filter.RankBySimilarity {
+		orderBy = "word_similarity(" + page.placeholder(filter.Title) + ", title) DESC, " + orderBy
+	}
+
+	//SQL query to get all movie records
+	//Has ORDER by in filter.go
+	query := fmt.Sprintf(`
+		SELECT %s, %s
+		FROM movies
+		WHERE %s
+		ORDER BY %s
+		LIMIT %s OFFSET %s`,
+		countColumn, selection.columns(), page, orderBy, limitArg, offsetArg)
 
 	//use QueryContext() to execute the query, returns sql.rows result set
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+	rows, err := m.DB.QueryContext(ctx, query, page.args...)
 	if err != nil {
 		return nil, Metadata{}, err // Update this to return an empty Metadata struct.
 	}
-	defer rows.Close()
 	//defer cal to close to allow result set to close before getAll
 	defer rows.Close()
 	totalRecords := 0
@@ -217,16 +429,7 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	for rows.Next() {
 		var movie Movie // init new movie struct to hold the data
 		//scan the values from the row into the struct
-		err := rows.Scan(
-			&totalRecords,
-			&movie.ID,
-			&movie.CreatedAt,
-			&movie.Title,
-			&movie.Year,
-			&movie.Runtime,
-			pq.Array(&movie.Genres),
-			&movie.Version,
-		)
+		err := rows.Scan(append([]interface{}{&totalRecords}, selection.dest(&movie)...)...)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
@@ -237,7 +440,95 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	if err = rows.Err(); err != nil {
 		return nil, Metadata{}, err
 	}
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
-	//if all ok, return slice of movies
+
+	if !filters.UseCursor {
+		if !filters.WithTotal {
+			return movies, Metadata{CurrentPage: filters.Page, PageSize: filters.PageSize, FirstPage: 1}, nil
+		}
+		metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+		//if all ok, return slice of movies
+		return movies, metadata, nil
+	}
+
+	metadata := Metadata{PageSize: filters.PageSize}
+	if len(movies) > filters.PageSize {
+		movies = movies[:filters.PageSize]
+		last := movies[len(movies)-1]
+		metadata.Next = filters.nextCursor(last.sortValue)
+	}
+	if filters.WithTotal {
+		//counted separately, a window count here would only see rows after the cursor
+		err = m.DB.QueryRowContext(ctx, "SELECT count(*) FROM movies WHERE "+where.String(), where.args...).Scan(&metadata.TotalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+	}
 	return movies, metadata, nil
 }
+
+// All returns every movie unpaged, for building in-memory models like the similar
+// movies one. Keep fields short, it reads the whole table
+func (m MovieModel) All(fields ...string) ([]*Movie, error) {
+	selection := selectMovieFields(fields)
+	query := `
+	SELECT ` + selection.columns() + `
+	FROM movies
+	WHERE deleted_at IS NULL
+	ORDER BY id`
+
+	//a full table read gets longer than the usual timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(selection.dest(&movie)...)
+		if err != nil {
+			return nil, err
+		}
+		movies = append(movies, &movie)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return movies, nil
+}
+
+// sortValue is the movies value for a sort column as text, for building cursors.
+// postgres casts it back to the columns type when it is compared
+func (movie *Movie) sortValue(column string) string {
+	switch column {
+	case "id":
+		return strconv.FormatInt(movie.ID, 10)
+	case "title":
+		return movie.Title
+	case "year":
+		return strconv.Itoa(int(movie.Year))
+	case "runtime":
+		return strconv.Itoa(int(movie.Runtime))
+	}
+	panic("no cursor value for sort column: " + column)
+}
+
+// lockLiveMovie holds a share lock on a movie that isnt in the trash until tx ends,
+// so it cant be deleted under a write that hangs off it. ErrRecordNotFound otherwise
+func lockLiveMovie(ctx context.Context, tx *sql.Tx, movieID int64) error {
+	var id int64
+	err := tx.QueryRowContext(ctx, `SELECT id FROM movies WHERE id = $1 AND deleted_at IS NULL FOR SHARE`, movieID).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// Rating is a users score for a movie out of 10
+type Rating struct {
+	MovieID int64     `json:"movie_id"`
+	Rating  int       `json:"rating"`
+	RatedAt time.Time `json:"rated_at"`
+}
+
+// UserRating is a rating with who gave it, for the similar movies model
+type UserRating struct {
+	UserID  int64
+	MovieID int64
+	Rating  int
+}
+
+// RatingSummary is what everyone thinks of a movie, Average is left out until
+// someone rates it
+type RatingSummary struct {
+	Average float64 `json:"average,omitempty"` //out of 10, to one decimal place
+	Count   int     `json:"count"`
+}
+
+func ValidateRating(v *validator.Validator, rating int) {
+	v.Check(rating >= 1 && rating <= 10, "rating", "must be between 1 and 10")
+}
+
+type RatingModel struct {
+	DB *sql.DB
+}
+
+// Set adds or changes a users rating, ErrRecordNotFound if the movie doesnt exist
+// or is in the trash
+func (m RatingModel) Set(userID, movieID int64, rating int) (*Rating, error) {
+	query := `
+	INSERT INTO movie_ratings (user_id, movie_id, rating)
+	SELECT $1, id, $3 FROM movies WHERE id = $2 AND deleted_at IS NULL
+	ON CONFLICT (user_id, movie_id) DO UPDATE SET rating = EXCLUDED.rating, rated_at = NOW()
+	RETURNING movie_id, rating, rated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	var r Rating
+	err := m.DB.QueryRowContext(ctx, query, userID, movieID, rating).Scan(&r.MovieID, &r.Rating, &r.RatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &r, nil
+}
+
+// Delete removes a users rating, ErrRecordNotFound if they hadnt rated the movie
+func (m RatingModel) Delete(userID, movieID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM movie_ratings WHERE user_id = $1 AND movie_id = $2`, userID, movieID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetForUser returns every rating a user has given to a movie thats not in the trash
+func (m RatingModel) GetForUser(userID int64) ([]Rating, error) {
+	query := `
+	SELECT movie_ratings.movie_id, movie_ratings.rating, movie_ratings.rated_at
+	FROM movie_ratings
+	INNER JOIN movies ON movies.id = movie_ratings.movie_id
+	WHERE movie_ratings.user_id = $1 AND movies.deleted_at IS NULL
+	ORDER BY movie_ratings.rated_at DESC, movie_ratings.movie_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ratings := []Rating{}
+	for rows.Next() {
+		var r Rating
+		err := rows.Scan(&r.MovieID, &r.Rating, &r.RatedAt)
+		if err != nil {
+			return nil, err
+		}
+		ratings = append(ratings, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return ratings, nil
+}
+
+// All returns every rating of a movie thats not in the trash, for the similar
+// movies model
+func (m RatingModel) All() ([]UserRating, error) {
+	query := `
+	SELECT movie_ratings.user_id, movie_ratings.movie_id, movie_ratings.rating
+	FROM movie_ratings
+	INNER JOIN movies ON movies.id = movie_ratings.movie_id
+	WHERE movies.deleted_at IS NULL`
+
+	//a full table read gets longer than the usual timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ratings := []UserRating{}
+	for rows.Next() {
+		var r UserRating
+		err := rows.Scan(&r.UserID, &r.MovieID, &r.Rating)
+		if err != nil {
+			return nil, err
+		}
+		ratings = append(ratings, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return ratings, nil
+}
+
+// SummaryForMovies averages the ratings of a page of movies in one query, every id
+// gets an entry even if nobody has rated it
+func (m RatingModel) SummaryForMovies(movieIDs []int64) (map[int64]*RatingSummary, error) {
+	query := `
+	SELECT movie_id, round(avg(rating), 1), count(*)
+	FROM movie_ratings
+	WHERE movie_id = ANY($1)
+	GROUP BY movie_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byMovie := make(map[int64]*RatingSummary, len(movieIDs))
+	for _, id := range movieIDs {
+		byMovie[id] = &RatingSummary{}
+	}
+	for rows.Next() {
+		var movieID int64
+		var summary RatingSummary
+		err := rows.Scan(&movieID, &summary.Average, &summary.Count)
+		if err != nil {
+			return nil, err
+		}
+		byMovie[movieID] = &summary
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return byMovie, nil
+}
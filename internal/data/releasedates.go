@@ -0,0 +1,234 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// how a movie is released in a region
+const (
+	ReleaseTheatrical = "theatrical"
+	ReleaseStreaming  = "streaming"
+	ReleasePhysical   = "physical"
+)
+
+var ReleaseTypes = []string{ReleaseTheatrical, ReleaseStreaming, ReleasePhysical}
+
+// movies can be announced this many years ahead of their release
+const MaxAnnouncedYears = 10
+
+var ErrInvalidDateFormat = errors.New("invalid date format, must be YYYY-MM-DD")
+
+// Date is a calendar day, it goes in and out of JSON as "2006-01-02"
+type Date struct {
+	time.Time
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(d.Format(time.DateOnly))), nil
+}
+
+func (d *Date) UnmarshalJSON(jsonValue []byte) error {
+	unquoted, err := strconv.Unquote(string(jsonValue))
+	if err != nil {
+		return ErrInvalidDateFormat
+	}
+	t, err := time.Parse(time.DateOnly, unquoted)
+	if err != nil {
+		return ErrInvalidDateFormat
+	}
+	d.Time = t
+	return nil
+}
+
+func (d Date) Value() (driver.Value, error) {
+	return d.Format(time.DateOnly), nil
+}
+
+func (d *Date) Scan(src interface{}) error {
+	t, ok := src.(time.Time)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into Date", src)
+	}
+	d.Time = t
+	return nil
+}
+
+type ReleaseDate struct {
+	Region string `json:"region"` //ISO 3166-1 country code
+	Type   string `json:"type"`   //one of ReleaseTypes
+	Date   Date   `json:"date"`
+}
+
+// UpcomingMovie is a movie plus its next release, the movie fields are flattened in JSON
+type UpcomingMovie struct {
+	*Movie
+	NextRelease ReleaseDate `json:"next_release"`
+}
+
+func ValidateReleaseDates(v *validator.Validator, dates []ReleaseDate) {
+	v.Check(dates != nil, "release_dates", "must be provided")
+	v.Check(len(dates) <= 200, "release_dates", "must not contain more than 200 entries")
+
+	seen := make(map[string]bool)
+	for _, d := range dates {
+		v.Check(validator.Matches(d.Region, CountryCodeRX), "release_dates", "region must be an uppercase ISO 3166-1 country code")
+		v.Check(validator.In(d.Type, ReleaseTypes...), "release_dates", "type must be theatrical, streaming or physical")
+		v.Check(!d.Date.IsZero(), "release_dates", "date must be provided")
+		v.Check(d.Date.Year() >= 1888, "release_dates", "date must not be before 1888")
+
+		key := d.Region + "/" + d.Type
+		v.Check(!seen[key], "release_dates", "must not contain the same region and type twice")
+		seen[key] = true
+	}
+}
+
+type ReleaseDateModel struct {
+	DB *sql.DB
+}
+
+// GetForMovie returns a movies release dates, earliest first
+func (m ReleaseDateModel) GetForMovie(movieID int64) ([]ReleaseDate, error) {
+	byMovie, err := m.GetForMovies([]int64{movieID})
+	if err != nil {
+		return nil, err
+	}
+	return byMovie[movieID], nil
+}
+
+// GetForMovies is GetForMovie for a page of movies in one query, every id
+// gets an entry even if it has no dates
+func (m ReleaseDateModel) GetForMovies(movieIDs []int64) (map[int64][]ReleaseDate, error) {
+	query := `
+	SELECT movie_id, region, release_type, release_date
+	FROM movie_release_dates
+	WHERE movie_id = ANY($1)
+	ORDER BY release_date, region, release_type`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byMovie := make(map[int64][]ReleaseDate, len(movieIDs))
+	for _, id := range movieIDs {
+		byMovie[id] = []ReleaseDate{}
+	}
+	for rows.Next() {
+		var movieID int64
+		var d ReleaseDate
+		err := rows.Scan(&movieID, &d.Region, &d.Type, &d.Date)
+		if err != nil {
+			return nil, err
+		}
+		byMovie[movieID] = append(byMovie[movieID], d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return byMovie, nil
+}
+
+// Replace swaps a movies whole set of release dates in one transaction, ErrRecordNotFound
+// if the movie doesnt exist or is in the trash
+func (m ReleaseDateModel) Replace(movieID int64, dates []ReleaseDate) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	//rollback is a no-op once commit has happened
+	defer tx.Rollback()
+
+	err = lockLiveMovie(ctx, tx, movieID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM movie_release_dates WHERE movie_id = $1`, movieID)
+	if err != nil {
+		return err
+	}
+
+	if len(dates) > 0 {
+		regions := make([]string, len(dates))
+		types := make([]string, len(dates))
+		days := make([]string, len(dates))
+		for i, d := range dates {
+			regions[i] = d.Region
+			types[i] = d.Type
+			days[i] = d.Date.Format(time.DateOnly)
+		}
+
+		query := `
+		INSERT INTO movie_release_dates (movie_id, region, release_type, release_date)
+		SELECT $1, * FROM unnest($2::text[], $3::text[], $4::date[])`
+
+		_, err = tx.ExecContext(ctx, query, movieID, pq.Array(regions), pq.Array(types), pq.Array(days))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Upcoming lists movies with a release on or after today, soonest first. region
+// limits it to one country, "" means any
+func (m ReleaseDateModel) Upcoming(region string, filters Filters) ([]*UpcomingMovie, Metadata, error) {
+	query := `
+	SELECT count(*) OVER(), ` + movieColumns + `, next.region, next.release_type, next.release_date
+	FROM movies
+	CROSS JOIN LATERAL (
+		SELECT region, release_type, release_date
+		FROM movie_release_dates
+		WHERE movie_release_dates.movie_id = movies.id
+		AND movie_release_dates.release_date >= CURRENT_DATE
+		AND (movie_release_dates.region = $1 OR $1 = '')
+		ORDER BY release_date, region, release_type
+		LIMIT 1
+	) AS next
+	WHERE movies.deleted_at IS NULL
+	ORDER BY next.release_date, movies.id
+	LIMIT $2 OFFSET $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, region, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	upcoming := []*UpcomingMovie{}
+	for rows.Next() {
+		item := UpcomingMovie{Movie: &Movie{}}
+		dest := append([]interface{}{&totalRecords}, item.Movie.scanDest()...)
+		dest = append(dest, &item.NextRelease.Region, &item.NextRelease.Type, &item.NextRelease.Date)
+		err := rows.Scan(dest...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		upcoming = append(upcoming, &item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	return upcoming, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
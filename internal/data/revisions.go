@@ -0,0 +1,228 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// MovieRevision is one version of a movie from movie_revisions. Changes compares its
+// snapshot with the one before, fields use the column names so runtime is plain minutes
+type MovieRevision struct {
+	Version   int32         `json:"version"`
+	Operation string        `json:"operation"` //insert, update, delete, restore or purge
+	UserID    *int64        `json:"user_id"`   //nil when the change didnt come through the api
+	CreatedAt time.Time     `json:"created_at"`
+	Changes   []FieldChange `json:"changes"`
+}
+
+// FieldChange is one field that differs between two revisions, From is null on insert
+type FieldChange struct {
+	Field string          `json:"field"`
+	From  json.RawMessage `json:"from"`
+	To    json.RawMessage `json:"to"`
+}
+
+// the movie_revisions trigger fills these in, no point diffing them
+var unDiffedRevisionFields = []string{"id", "version", "created_at"}
+
+// movieSnapshot is the shape of movie_revisions.snapshot, just the fields a revert puts back
+type movieSnapshot struct {
+	Title            string         `json:"title"`
+	Year             int32          `json:"year"`
+	Runtime          int32          `json:"runtime"`
+	Genres           []string       `json:"genres"`
+	Synopsis         string         `json:"synopsis"`
+	OriginalTitle    string         `json:"original_title"`
+	OriginalLanguage string         `json:"original_language"`
+	SpokenLanguages  []string       `json:"spoken_languages"`
+	Certifications   Certifications `json:"certifications"`
+	ExternalIDs      ExternalIDs    `json:"external_ids"`
+}
+
+type RevisionModel struct {
+	DB *sql.DB
+}
+
+// As returns a copy of the model whose writes get recorded against userID in
+// movie_revisions, 0 is the anonymous user and records nothing
+func (m MovieModel) As(userID int64) MovieModel {
+	m.actor = userID
+	return m
+}
+
+// write runs fn in a transaction tagged with m.actor, so the movie_revisions trigger
+// knows who made the change
+func (m MovieModel) write(fn func(q querier) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = setActor(ctx, tx, m.actor)
+	if err != nil {
+		return err
+	}
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// setActor sets greenlight.user_id until the end of tx
+func setActor(ctx context.Context, tx *sql.Tx, userID int64) error {
+	if userID == 0 {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, "SELECT set_config('greenlight.user_id', $1, true)", strconv.FormatInt(userID, 10))
+	return err
+}
+
+// GetAll lists a movies revisions newest first, ErrRecordNotFound if it has none.
+// Purged movies keep their history so this works for them too
+func (m RevisionModel) GetAll(movieID int64, filters Filters) ([]*MovieRevision, Metadata, error) {
+	if movieID < 1 {
+		return nil, Metadata{}, ErrRecordNotFound
+	}
+	//lag runs over the whole history before the LIMIT, so the oldest revision on a
+	//page still gets diffed against the one before it
+	query := `
+	SELECT count(*) OVER(), version, operation, user_id, created_at, snapshot, previous
+	FROM (
+		SELECT *, lag(snapshot) OVER (ORDER BY id) AS previous
+		FROM movie_revisions
+		WHERE movie_id = $1
+	) AS revisions
+	ORDER BY id DESC
+	LIMIT $2 OFFSET $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*MovieRevision{}
+	for rows.Next() {
+		var revision MovieRevision
+		var snapshot, previous []byte
+		err := rows.Scan(&totalRecords, &revision.Version, &revision.Operation, &revision.UserID, &revision.CreatedAt, &snapshot, &previous)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		revision.Changes, err = diffSnapshots(previous, snapshot)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		revisions = append(revisions, &revision)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	//past the last page is fine, no revisions at all means no such movie
+	if totalRecords == 0 && filters.Page == 1 {
+		return nil, Metadata{}, ErrRecordNotFound
+	}
+	return revisions, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Snapshot returns the movie as it was at version, only the fields Update writes are
+// filled in. Purge revisions arent a state the movie was ever in so they are skipped
+func (m RevisionModel) Snapshot(movieID int64, version int32) (*Movie, error) {
+	if movieID < 1 || version < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+	SELECT snapshot
+	FROM movie_revisions
+	WHERE movie_id = $1 AND version = $2 AND operation <> 'purge'
+	ORDER BY id DESC
+	LIMIT 1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	var raw []byte
+	err := m.DB.QueryRowContext(ctx, query, movieID, version).Scan(&raw)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	var snapshot movieSnapshot
+	err = json.Unmarshal(raw, &snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return &Movie{
+		ID:               movieID,
+		Title:            snapshot.Title,
+		Year:             snapshot.Year,
+		Runtime:          Runtime(snapshot.Runtime),
+		Genres:           snapshot.Genres,
+		Synopsis:         snapshot.Synopsis,
+		OriginalTitle:    snapshot.OriginalTitle,
+		OriginalLanguage: snapshot.OriginalLanguage,
+		SpokenLanguages:  snapshot.SpokenLanguages,
+		Certifications:   snapshot.Certifications,
+		ExternalIDs:      snapshot.ExternalIDs,
+	}, nil
+}
+
+// diffSnapshots compares two snapshot objects field by field, previous is nil for the
+// first revision. jsonb output is normalised so equal values come out byte for byte equal
+func diffSnapshots(previous, current []byte) ([]FieldChange, error) {
+	var before, after map[string]json.RawMessage
+	if previous != nil {
+		if err := json.Unmarshal(previous, &before); err != nil {
+			return nil, err
+		}
+	}
+	if err := json.Unmarshal(current, &after); err != nil {
+		return nil, err
+	}
+
+	fields := make([]string, 0, len(after))
+	for field := range after {
+		fields = append(fields, field)
+	}
+	for field := range before {
+		if _, ok := after[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	changes := []FieldChange{}
+	for _, field := range fields {
+		if validator.In(field, unDiffedRevisionFields...) {
+			continue
+		}
+		from, to := before[field], after[field]
+		if bytes.Equal(from, to) {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: field, From: from, To: to})
+	}
+	return changes, nil
+}
@@ -0,0 +1,121 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+// reindexing every movie after a language change can take a while on a big table
+const searchReindexTimeout = 5 * time.Minute
+
+// SearchResult is one hit from GET /v1/search
+type SearchResult struct {
+	Movie    *Movie  `json:"movie"`
+	Rank     float32 `json:"rank"`
+	Headline string  `json:"headline"` //title and synopsis, HTML escaped, with the matches wrapped in <b></b>
+}
+
+// ts_headline marks matches with these and leaves the text as it is, so the text
+// is escaped before they become <b></b>. Control characters cant be in the text
+// to start with, the query strips them
+const (
+	headlineStart = "\x01"
+	headlineStop  = "\x02"
+)
+
+var headlineTags = strings.NewReplacer(headlineStart, "<b>", headlineStop, "</b>")
+
+type SearchModel struct {
+	DB *sql.DB
+}
+
+func ValidateSearchQuery(v *validator.Validator, q string) {
+	v.Check(q != "", "q", "must be provided")
+	v.Check(len(q) <= 500, "q", "must not be more than 500 bytes long")
+}
+
+// SetLanguage switches the text search config, like "english" or "simple", and
+// rebuilds every movies search vector if it changed. Unknown configs error
+func (m SearchModel) SetLanguage(language string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), searchReindexTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+	UPDATE search_config SET language = $1::regconfig
+	WHERE language <> $1::regconfig`, language)
+	if err != nil {
+		return fmt.Errorf("search language %q: %w", language, err)
+	}
+	changed, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if changed == 0 {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	UPDATE movies
+	SET search_vector = movie_search_vector($1::regconfig, title, synopsis, original_title, movie_credit_names(id))`, language)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Search ranks movies against q, which takes web search syntax: "quoted phrases",
+// or, and -excluded words
+func (m SearchModel) Search(q string, filters Filters) ([]*SearchResult, Metadata, error) {
+	query := fmt.Sprintf(`
+	WITH q AS (
+		SELECT language, websearch_to_tsquery(language, $1) AS query
+		FROM search_config
+	)
+	SELECT count(*) OVER(), %s,
+		ts_rank(movies.search_vector, q.query) AS rank,
+		ts_headline(q.language, translate(concat_ws(' - ', movies.title, NULLIF(movies.synopsis, '')), $4::text, ''), q.query,
+			'StartSel=' || $5::text || ', StopSel=' || $6::text || ', MaxFragments=2, MaxWords=30, MinWords=10')
+	FROM movies, q
+	WHERE movies.search_vector @@ q.query AND movies.deleted_at IS NULL
+	ORDER BY rank DESC, movies.id ASC
+	LIMIT $2 OFFSET $3`, movieColumns)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, q, filters.limit(), filters.offset(),
+		headlineStart+headlineStop, headlineStart, headlineStop)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	results := []*SearchResult{}
+	for rows.Next() {
+		result := SearchResult{Movie: &Movie{}}
+		dest := append([]interface{}{&totalRecords}, result.Movie.scanDest()...)
+		err := rows.Scan(append(dest, &result.Rank, &result.Headline)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		result.Headline = headlineTags.Replace(html.EscapeString(result.Headline))
+		results = append(results, &result)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	return results, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
@@ -0,0 +1,497 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.alexedwards.net/internal/validator"
+)
+
+var (
+	ErrAlreadyInSeries = errors.New("movie already in series")
+	ErrUnknownMovie    = errors.New("unknown movie")
+)
+
+// Series groups movies in watch order, like a franchise
+type Series struct {
+	ID          int64          `json:"id"`
+	CreatedAt   time.Time      `json:"-"`
+	Title       string         `json:"title"`
+	Description string         `json:"description,omitempty"`
+	Movies      []*SeriesEntry `json:"movies,omitempty"` //ordered by position
+	Version     int32          `json:"version"`
+}
+
+// SeriesEntry is a short form of a movie at a spot in a series
+type SeriesEntry struct {
+	Position int    `json:"position"`
+	ID       int64  `json:"id"`
+	Title    string `json:"title"`
+	Year     int32  `json:"year,omitempty"`
+}
+
+// MovieSeries is what showMovieHandler adds to a movie, one per series it is in,
+// with its neighbours so clients can render watch order navigation
+type MovieSeries struct {
+	ID       int64        `json:"id"`
+	Title    string       `json:"title"`
+	Position int          `json:"position"`
+	Previous *SeriesEntry `json:"previous"`
+	Next     *SeriesEntry `json:"next"`
+}
+
+func ValidateSeries(v *validator.Validator, series *Series) {
+	v.Check(series.Title != "", "title", "must be provided")
+	v.Check(len(series.Title) <= 500, "title", "must not be more than 500 bytes long")
+	v.Check(len(series.Description) <= 5000, "description", "must not be more than 5000 bytes long")
+}
+
+func ValidateSeriesMovieIDs(v *validator.Validator, movieIDs []int64) {
+	v.Check(movieIDs != nil, "movie_ids", "must be provided")
+	v.Check(len(movieIDs) <= 100, "movie_ids", "must not contain more than 100 movies")
+
+	seen := make(map[int64]bool)
+	for _, id := range movieIDs {
+		v.Check(id > 0, "movie_ids", "must only contain positive IDs")
+		v.Check(!seen[id], "movie_ids", "must not contain duplicate values")
+		seen[id] = true
+	}
+}
+
+type SeriesModel struct {
+	DB *sql.DB
+}
+
+func (m SeriesModel) Insert(series *Series) error {
+	query := `
+	INSERT INTO series (title, description)
+	VALUES ($1, $2)
+	RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, series.Title, series.Description).Scan(&series.ID, &series.CreatedAt, &series.Version)
+}
+
+// Get returns a series with its movies in order
+func (m SeriesModel) Get(id int64) (*Series, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+	SELECT id, created_at, title, description, version
+	FROM series
+	WHERE id = $1`
+
+	var series Series
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&series.ID, &series.CreatedAt, &series.Title, &series.Description, &series.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	query = `
+	SELECT series_movies.position, movies.id, movies.title, movies.year
+	FROM series_movies
+	INNER JOIN movies ON movies.id = series_movies.movie_id AND movies.deleted_at IS NULL
+	WHERE series_movies.series_id = $1
+	ORDER BY series_movies.position`
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	series.Movies = []*SeriesEntry{}
+	for rows.Next() {
+		var entry SeriesEntry
+		err := rows.Scan(&entry.Position, &entry.ID, &entry.Title, &entry.Year)
+		if err != nil {
+			return nil, err
+		}
+		series.Movies = append(series.Movies, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return &series, nil
+}
+
+// GetAll lists series without their movies
+func (m SeriesModel) GetAll(title string, filters Filters) ([]*Series, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, title, description, version
+	FROM series
+	WHERE (title ILIKE '%%' || $1 || '%%' OR $1 = '')
+	ORDER BY %s
+	LIMIT $2 OFFSET $3`, filters.orderBy())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, title, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	all := []*Series{}
+	for rows.Next() {
+		var series Series
+		err := rows.Scan(&totalRecords, &series.ID, &series.CreatedAt, &series.Title, &series.Description, &series.Version)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		all = append(all, &series)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	return all, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+func (m SeriesModel) Update(series *Series) error {
+	query := `
+	UPDATE series
+	SET title = $1, description = $2, version = version + 1
+	WHERE id = $3 AND version = $4
+	RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, series.Title, series.Description, series.ID, series.Version).Scan(&series.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m SeriesModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM series WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// ReplaceMovies sets the full member list, positions follow the order of movieIDs.
+// Members in the trash arent in movieIDs because clients cant see them, they keep
+// their place so restoring the movie puts it back where it was
+func (m SeriesModel) ReplaceMovies(seriesID int64, movieIDs []int64) error {
+	return m.withLockedSeries(seriesID, func(ctx context.Context, tx *sql.Tx) error {
+		err := lockLiveMovies(ctx, tx, movieIDs)
+		if err != nil {
+			return err
+		}
+
+		query := `
+		SELECT series_movies.movie_id, series_movies.position
+		FROM series_movies
+		INNER JOIN movies ON movies.id = series_movies.movie_id AND movies.deleted_at IS NOT NULL
+		WHERE series_movies.series_id = $1
+		ORDER BY series_movies.position`
+
+		rows, err := tx.QueryContext(ctx, query, seriesID)
+		if err != nil {
+			return err
+		}
+		var trashed []SeriesEntry
+		for rows.Next() {
+			var entry SeriesEntry
+			err := rows.Scan(&entry.ID, &entry.Position)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			trashed = append(trashed, entry)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM series_movies WHERE series_id = $1`, seriesID)
+		if err != nil {
+			return err
+		}
+
+		query = `
+		INSERT INTO series_movies (series_id, movie_id, position)
+		SELECT $1, ids.movie_id, ids.position
+		FROM unnest($2::bigint[]) WITH ORDINALITY AS ids(movie_id, position)`
+
+		_, err = tx.ExecContext(ctx, query, seriesID, pq.Array(seriesOrder(movieIDs, trashed)))
+		return seriesMemberError(err)
+	})
+}
+
+// seriesOrder merges trashed members back in with the live ones. Each trashed movie
+// keeps its old position if the list is still that long, otherwise it goes as near
+// the end as it can, and the live movies fill the other positions in order
+func seriesOrder(live []int64, trashed []SeriesEntry) []int64 {
+	total := len(live) + len(trashed)
+	order := make([]int64, total)
+	prev := 0
+	for i, entry := range trashed {
+		//leave room for the trashed ones still to come
+		position := min(entry.Position, total-(len(trashed)-1-i))
+		position = max(position, prev+1)
+		order[position-1] = entry.ID
+		prev = position
+	}
+	next := 0
+	for i := range order {
+		if order[i] == 0 {
+			order[i] = live[next]
+			next++
+		}
+	}
+	return order
+}
+
+// AddMovie puts a movie in at position, shifting the ones after it along.
+// position 0 (or anything past the end) appends it
+func (m SeriesModel) AddMovie(seriesID, movieID int64, position int) error {
+	return m.withLockedSeries(seriesID, func(ctx context.Context, tx *sql.Tx) error {
+		err := lockLiveMovies(ctx, tx, []int64{movieID})
+		if err != nil {
+			return err
+		}
+
+		var last int
+		err = tx.QueryRowContext(ctx, `SELECT coalesce(max(position), 0) FROM series_movies WHERE series_id = $1`, seriesID).Scan(&last)
+		if err != nil {
+			return err
+		}
+		if position < 1 || position > last {
+			position = last + 1
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE series_movies SET position = position + 1 WHERE series_id = $1 AND position >= $2`, seriesID, position)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO series_movies (series_id, movie_id, position) VALUES ($1, $2, $3)`, seriesID, movieID, position)
+		return seriesMemberError(err)
+	})
+}
+
+// lockLiveMovies is lockLiveMovie for a list, ErrUnknownMovie if any of them dont
+// exist or are in the trash
+func lockLiveMovies(ctx context.Context, tx *sql.Tx, movieIDs []int64) error {
+	if len(movieIDs) == 0 {
+		return nil
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM movies WHERE id = ANY($1) AND deleted_at IS NULL FOR SHARE`, pq.Array(movieIDs))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	found := 0
+	for rows.Next() {
+		found++
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	//ids are unique, ValidateSeriesMovieIDs checks
+	if found != len(movieIDs) {
+		return ErrUnknownMovie
+	}
+	return nil
+}
+
+// RemoveMovie takes a movie out and closes the gap it leaves
+func (m SeriesModel) RemoveMovie(seriesID, movieID int64) error {
+	return m.withLockedSeries(seriesID, func(ctx context.Context, tx *sql.Tx) error {
+		var position int
+		err := tx.QueryRowContext(ctx, `DELETE FROM series_movies WHERE series_id = $1 AND movie_id = $2 RETURNING position`, seriesID, movieID).Scan(&position)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE series_movies SET position = position - 1 WHERE series_id = $1 AND position > $2`, seriesID, position)
+		return err
+	})
+}
+
+// withLockedSeries runs fn in a transaction holding the series row lock, then bumps
+// the series version so membership changes show up like any other edit
+func (m SeriesModel) withLockedSeries(seriesID int64, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `SELECT id FROM series WHERE id = $1 FOR UPDATE`, seriesID).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = fn(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE series SET version = version + 1 WHERE id = $1`, seriesID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// turns constraint violations on series_movies into our own errors
+func seriesMemberError(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch {
+	case err.Error() == `pq: duplicate key value violates unique constraint "series_movies_pkey"`:
+		return ErrAlreadyInSeries
+	case err.Error() == `pq: insert or update on table "series_movies" violates foreign key constraint "series_movies_movie_id_fkey"`:
+		return ErrUnknownMovie
+	default:
+		return err
+	}
+}
+
+// ForMovie returns every series a movie belongs to with the movies either side of it
+func (m SeriesModel) ForMovie(movieID int64) ([]*MovieSeries, error) {
+	byMovie, err := m.ForMovies([]int64{movieID})
+	if err != nil {
+		return nil, err
+	}
+	return byMovie[movieID], nil
+}
+
+// ForMovies is ForMovie for a page of movies in one query, every id gets an entry
+func (m SeriesModel) ForMovies(movieIDs []int64) (map[int64][]*MovieSeries, error) {
+	query := `
+	SELECT member.movie_id, series.id, series.title, member.position,
+		prev_member.position, prev_movie.id, prev_movie.title, prev_movie.year,
+		next_member.position, next_movie.id, next_movie.title, next_movie.year
+	FROM series_movies AS member
+	INNER JOIN series ON series.id = member.series_id
+	LEFT JOIN LATERAL (
+		SELECT series_movies.movie_id, series_movies.position FROM series_movies
+		INNER JOIN movies ON movies.id = series_movies.movie_id AND movies.deleted_at IS NULL
+		WHERE series_movies.series_id = member.series_id AND series_movies.position < member.position
+		ORDER BY series_movies.position DESC
+		LIMIT 1
+	) AS prev_member ON true
+	LEFT JOIN movies AS prev_movie ON prev_movie.id = prev_member.movie_id
+	LEFT JOIN LATERAL (
+		SELECT series_movies.movie_id, series_movies.position FROM series_movies
+		INNER JOIN movies ON movies.id = series_movies.movie_id AND movies.deleted_at IS NULL
+		WHERE series_movies.series_id = member.series_id AND series_movies.position > member.position
+		ORDER BY series_movies.position
+		LIMIT 1
+	) AS next_member ON true
+	LEFT JOIN movies AS next_movie ON next_movie.id = next_member.movie_id
+	WHERE member.movie_id = ANY($1)
+	ORDER BY series.title, series.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byMovie := make(map[int64][]*MovieSeries, len(movieIDs))
+	for _, id := range movieIDs {
+		byMovie[id] = []*MovieSeries{}
+	}
+	for rows.Next() {
+		var movieID int64
+		var ms MovieSeries
+		var prev, next nullSeriesEntry
+		err := rows.Scan(&movieID, &ms.ID, &ms.Title, &ms.Position,
+			&prev.position, &prev.id, &prev.title, &prev.year,
+			&next.position, &next.id, &next.title, &next.year)
+		if err != nil {
+			return nil, err
+		}
+		ms.Previous = prev.entry()
+		ms.Next = next.entry()
+		byMovie[movieID] = append(byMovie[movieID], &ms)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return byMovie, nil
+}
+
+// the previous/next columns are all NULL at either end of a series
+type nullSeriesEntry struct {
+	position sql.NullInt32
+	id       sql.NullInt64
+	title    sql.NullString
+	year     sql.NullInt32
+}
+
+func (n nullSeriesEntry) entry() *SeriesEntry {
+	if !n.id.Valid {
+		return nil
+	}
+	return &SeriesEntry{
+		Position: int(n.position.Int32),
+		ID:       n.id.Int64,
+		Title:    n.title.String,
+		Year:     n.year.Int32,
+	}
+}
@@ -0,0 +1,110 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// TrashedMovie is a soft deleted movie in GET /v1/movies/trash
+type TrashedMovie struct {
+	*Movie
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// Restore takes a movie back out of the trash, ErrRecordNotFound if it isnt in there
+func (m MovieModel) Restore(id int64) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+	UPDATE movies
+	SET deleted_at = NULL, version = version + 1
+	WHERE id = $1 AND deleted_at IS NOT NULL
+	RETURNING ` + movieColumns
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	var movie Movie
+	err := m.write(func(q querier) error {
+		return q.QueryRowContext(ctx, query, id).Scan(movie.scanDest()...)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &movie, nil
+}
+
+// GetTrash lists deleted movies, most recently deleted first
+func (m MovieModel) GetTrash(filters Filters) ([]*TrashedMovie, Metadata, error) {
+	query := `
+	SELECT count(*) OVER(), ` + movieColumns + `, movies.deleted_at
+	FROM movies
+	WHERE deleted_at IS NOT NULL
+	ORDER BY deleted_at DESC, id ASC
+	LIMIT $1 OFFSET $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*TrashedMovie{}
+	for rows.Next() {
+		item := TrashedMovie{Movie: &Movie{}}
+		dest := append([]interface{}{&totalRecords}, item.Movie.scanDest()...)
+		err := rows.Scan(append(dest, &item.DeletedAt)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		movies = append(movies, &item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	return movies, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// PurgeTrash permanently deletes movies that went in the trash before cutoff. The
+// purged movies come back with their poster URLs so the files can go too
+func (m MovieModel) PurgeTrash(cutoff time.Time) ([]*Movie, error) {
+	query := `
+	DELETE FROM movies
+	WHERE deleted_at < $1
+	RETURNING id, poster_url, poster_thumbnail_url`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	purged := []*Movie{}
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(&movie.ID, &movie.PosterURL, &movie.PosterThumbnailURL)
+		if err != nil {
+			return nil, err
+		}
+		purged = append(purged, &movie)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return purged, nil
+}
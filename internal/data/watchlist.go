@@ -0,0 +1,133 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// WatchlistEntry is a movie a user wants to watch, the movie fields are flattened in JSON
+type WatchlistEntry struct {
+	*Movie
+	AddedAt time.Time `json:"added_at"`
+}
+
+type WatchlistModel struct {
+	DB *sql.DB
+}
+
+// Add puts a movie on a users watchlist, adding it twice is fine. ErrRecordNotFound
+// if the movie doesnt exist or is in the trash
+func (m WatchlistModel) Add(userID, movieID int64) error {
+	query := `
+	INSERT INTO watchlist (user_id, movie_id)
+	SELECT $1, id FROM movies WHERE id = $2 AND deleted_at IS NULL
+	ON CONFLICT (user_id, movie_id) DO UPDATE SET user_id = EXCLUDED.user_id
+	RETURNING movie_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	//the no-op update is so RETURNING has a row when it was already there
+	var id int64
+	err := m.DB.QueryRowContext(ctx, query, userID, movieID).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove takes a movie off a users watchlist, ErrRecordNotFound if it wasnt on it
+func (m WatchlistModel) Remove(userID, movieID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM watchlist WHERE user_id = $1 AND movie_id = $2`, userID, movieID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetAll lists a users watchlist, most recently added first. Trashed movies are
+// hidden and come back if they are restored
+func (m WatchlistModel) GetAll(userID int64, filters Filters) ([]*WatchlistEntry, Metadata, error) {
+	query := `
+	SELECT count(*) OVER(), ` + movieColumns + `, watchlist.added_at
+	FROM watchlist
+	INNER JOIN movies ON movies.id = watchlist.movie_id
+	WHERE watchlist.user_id = $1 AND movies.deleted_at IS NULL
+	ORDER BY watchlist.added_at DESC, movies.id
+	LIMIT $2 OFFSET $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*WatchlistEntry{}
+	for rows.Next() {
+		entry := WatchlistEntry{Movie: &Movie{}}
+		dest := append([]interface{}{&totalRecords}, entry.Movie.scanDest()...)
+		dest = append(dest, &entry.AddedAt)
+		err := rows.Scan(dest...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		entries = append(entries, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	return entries, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// MovieIDs is every movie on a users watchlist that isnt in the trash
+func (m WatchlistModel) MovieIDs(userID int64) ([]int64, error) {
+	query := `
+	SELECT watchlist.movie_id
+	FROM watchlist
+	INNER JOIN movies ON movies.id = watchlist.movie_id
+	WHERE watchlist.user_id = $1 AND movies.deleted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sql_timeout)*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
@@ -0,0 +1,54 @@
+package data
+
+import (
+	"strconv"
+	"strings"
+)
+
+// whereBuilder collects AND-ed SQL conditions along with their args. Conditions use
+// ? where a value goes and it gets swapped for the next $n, so values always go in as
+// parameters and never get pasted into the SQL. Dont use it for the jsonb ? operators
+type whereBuilder struct {
+	conds []string
+	args  []interface{}
+}
+
+// add appends a condition, there must be one arg per ?
+func (w *whereBuilder) add(cond string, args ...interface{}) {
+	if strings.Count(cond, "?") != len(args) {
+		panic("whereBuilder: placeholder count does not match args in: " + cond)
+	}
+	var b strings.Builder
+	i := 0
+	for _, r := range cond {
+		if r == '?' {
+			b.WriteString(w.placeholder(args[i]))
+			i++
+			continue
+		}
+		b.WriteRune(r)
+	}
+	w.conds = append(w.conds, "("+b.String()+")")
+}
+
+// placeholder adds an arg without a condition and returns its $n, for LIMIT and friends
+func (w *whereBuilder) placeholder(arg interface{}) string {
+	w.args = append(w.args, arg)
+	return "$" + strconv.Itoa(len(w.args))
+}
+
+// clone copies the builder so one base filter can grow in different directions
+func (w *whereBuilder) clone() *whereBuilder {
+	return &whereBuilder{
+		conds: append([]string(nil), w.conds...),
+		args:  append([]interface{}(nil), w.args...),
+	}
+}
+
+// String is the WHERE body, TRUE if there is nothing to filter on
+func (w *whereBuilder) String() string {
+	if len(w.conds) == 0 {
+		return "TRUE"
+	}
+	return strings.Join(w.conds, " AND ")
+}
@@ -0,0 +1,118 @@
+package images
+
+//Small helpers for uploaded images, only uses the standard library decoders
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+var (
+	ErrUnsupportedType = errors.New("unsupported image type")
+	ErrInvalidImage    = errors.New("invalid image data")
+)
+
+// allowed content types and the decoder for each
+var decoders = map[string]func([]byte) (image.Image, error){
+	"image/jpeg": func(b []byte) (image.Image, error) { return jpeg.Decode(bytes.NewReader(b)) },
+	"image/png":  func(b []byte) (image.Image, error) { return png.Decode(bytes.NewReader(b)) },
+	"image/gif":  func(b []byte) (image.Image, error) { return gif.Decode(bytes.NewReader(b)) },
+}
+
+// Extensions for each supported content type, used when naming stored files
+var Extensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// DetectType sniffs the real content type from the bytes, we dont trust the
+// type the client sent
+func DetectType(b []byte) (string, error) {
+	contentType := http.DetectContentType(b)
+	if _, ok := decoders[contentType]; !ok {
+		return "", ErrUnsupportedType
+	}
+	return contentType, nil
+}
+
+// Dimensions reads only the header so we can reject huge images before decoding them
+func Dimensions(b []byte) (width, height int, err error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return 0, 0, ErrInvalidImage
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+func Decode(b []byte, contentType string) (image.Image, error) {
+	decode, ok := decoders[contentType]
+	if !ok {
+		return nil, ErrUnsupportedType
+	}
+	img, err := decode(b)
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	return img, nil
+}
+
+// Thumbnail scales img down to maxWidth wide keeping the aspect ratio and
+// returns it as a JPEG. Images already narrower than that are only re-encoded
+func Thumbnail(img image.Image, maxWidth int) ([]byte, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > maxWidth {
+		height = max(1, height*maxWidth/width)
+		width = maxWidth
+	}
+
+	buf := new(bytes.Buffer)
+	err := jpeg.Encode(buf, resize(img, width, height), &jpeg.Options{Quality: 85})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// resize does a box filter, each output pixel is the average of the source
+// pixels it covers. Plenty good enough for shrinking posters
+func resize(src image.Image, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	sb := src.Bounds()
+	sw, sh := sb.Dx(), sb.Dy()
+
+	for y := 0; y < height; y++ {
+		y0 := sb.Min.Y + y*sh/height
+		y1 := max(y0+1, sb.Min.Y+(y+1)*sh/height)
+		for x := 0; x < width; x++ {
+			x0 := sb.Min.X + x*sw/width
+			x1 := max(x0+1, sb.Min.X+(x+1)*sw/width)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					b += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}
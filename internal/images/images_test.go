@@ -0,0 +1,100 @@
+package images
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDetectAndDecode(t *testing.T) {
+	b := encodePNG(t, image.NewRGBA(image.Rect(0, 0, 40, 30)))
+
+	contentType, err := DetectType(b)
+	if err != nil || contentType != "image/png" {
+		t.Fatalf("DetectType = %q, %v", contentType, err)
+	}
+	w, h, err := Dimensions(b)
+	if err != nil || w != 40 || h != 30 {
+		t.Fatalf("Dimensions = %d, %d, %v", w, h, err)
+	}
+	if _, err := Decode(b, contentType); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	if _, err := DetectType([]byte("%PDF-1.4 not an image")); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("DetectType(pdf) = %v, want ErrUnsupportedType", err)
+	}
+	if _, _, err := Dimensions([]byte("junk")); !errors.Is(err, ErrInvalidImage) {
+		t.Errorf("Dimensions(junk) = %v, want ErrInvalidImage", err)
+	}
+	if _, err := Decode(b[:20], "image/png"); !errors.Is(err, ErrInvalidImage) {
+		t.Errorf("Decode(truncated) = %v, want ErrInvalidImage", err)
+	}
+}
+
+func TestThumbnailSize(t *testing.T) {
+	tests := []struct {
+		width, height, maxWidth int
+		wantW, wantH            int
+	}{
+		{1000, 1500, 300, 300, 450},
+		{200, 300, 300, 200, 300}, //narrower already, left alone
+		{3000, 2, 300, 300, 1},    //never 0 pixels high
+	}
+	for _, tt := range tests {
+		b, err := Thumbnail(image.NewRGBA(image.Rect(0, 0, tt.width, tt.height)), tt.maxWidth)
+		if err != nil {
+			t.Fatalf("Thumbnail(%dx%d): %v", tt.width, tt.height, err)
+		}
+		w, h, err := Dimensions(b)
+		if err != nil || w != tt.wantW || h != tt.wantH {
+			t.Errorf("Thumbnail(%dx%d, %d) = %dx%d, %v, want %dx%d", tt.width, tt.height, tt.maxWidth, w, h, err, tt.wantW, tt.wantH)
+		}
+	}
+}
+
+func TestResizeAverages(t *testing.T) {
+	//left half black, right half white, shrunk to 2x1 keeps the halves and to 1x1
+	//averages them to grey
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 2; x < 4; x++ {
+			src.Set(x, y, color.White)
+		}
+	}
+	for x := 0; x < 2; x++ {
+		src.Set(x, 0, color.Black)
+		src.Set(x, 1, color.Black)
+	}
+
+	dst := resize(src, 2, 1)
+	if got := dst.RGBAAt(0, 0); got != (color.RGBA{0, 0, 0, 255}) {
+		t.Errorf("left pixel = %v, want black", got)
+	}
+	if got := dst.RGBAAt(1, 0); got != (color.RGBA{255, 255, 255, 255}) {
+		t.Errorf("right pixel = %v, want white", got)
+	}
+
+	grey := resize(src, 1, 1).RGBAAt(0, 0)
+	if grey.R < 126 || grey.R > 128 || grey.A != 255 {
+		t.Errorf("averaged pixel = %v, want mid grey", grey)
+	}
+
+	//a source that doesnt start at 0,0
+	sub := src.SubImage(image.Rect(2, 0, 4, 2))
+	if got := resize(sub, 1, 1).RGBAAt(0, 0); got != (color.RGBA{255, 255, 255, 255}) {
+		t.Errorf("sub image pixel = %v, want white", got)
+	}
+}
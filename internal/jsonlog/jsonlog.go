@@ -38,10 +38,13 @@ func (l Level) String() string {
 
 // custom logger type for our output destination to write log to
 // Min sev holds
+// fields go on every entry, see With. The mutex is a pointer so loggers from With
+// share it with their parent
 type Logger struct {
 	out      io.Writer
 	minLevel Level
-	mu       sync.Mutex
+	mu       *sync.Mutex
+	fields   map[string]string
 }
 
 // new logger instance to write log entries at or above Min Sev
@@ -49,6 +52,23 @@ func New(out io.Writer, minLevel Level) *Logger {
 	return &Logger{
 		out:      out,
 		minLevel: minLevel,
+		mu:       &sync.Mutex{},
+	}
+}
+
+// With returns a logger that adds key to the properties of every entry, like a
+// request ID. Properties passed to a Print method win over it
+func (l *Logger) With(key, value string) *Logger {
+	fields := make(map[string]string, len(l.fields)+1)
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	fields[key] = value
+	return &Logger{
+		out:      l.out,
+		minLevel: l.minLevel,
+		mu:       l.mu,
+		fields:   fields,
 	}
 }
 
@@ -72,6 +92,17 @@ func (l *Logger) print(level Level, message string, properties map[string]string
 	if level < l.minLevel {
 		return 0, nil
 	}
+	//fields from With go in first so the callers properties can override them
+	if len(l.fields) > 0 {
+		merged := make(map[string]string, len(l.fields)+len(properties))
+		for k, v := range l.fields {
+			merged[k] = v
+		}
+		for k, v := range properties {
+			merged[k] = v
+		}
+		properties = merged
+	}
 	//create anon struct holding data for the log entry
 	aux := struct {
 		Level      string            `json:"level"`
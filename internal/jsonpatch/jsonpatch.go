@@ -0,0 +1,319 @@
+// Package jsonpatch applies JSON Merge Patches (RFC 7396) and JSON Patches (RFC 6902)
+// to JSON documents. JSON Patch support covers the add, remove, replace and test ops.
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidPatch = errors.New("invalid patch")
+	ErrPathNotFound = errors.New("path not found")
+	ErrTestFailed   = errors.New("test failed")
+)
+
+// MergePatch applies an RFC 7396 merge patch to doc. Objects in the patch are merged
+// key by key, null removes a key and anything else replaces what was there
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	target, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+	p, err := decode(patch)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	return json.Marshal(merge(target, p))
+}
+
+func merge(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for key, value := range p {
+		if value == nil {
+			delete(t, key)
+			continue
+		}
+		t[key] = merge(t[key], value)
+	}
+	return t
+}
+
+// Operation is one step of a JSON Patch
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Patch is an RFC 6902 JSON Patch, the operations run in order and stop at the first error
+type Patch []Operation
+
+// Decode parses and checks a JSON Patch document
+func Decode(b []byte) (Patch, error) {
+	var patch Patch
+	err := json.Unmarshal(b, &patch)
+	if err != nil {
+		return nil, fmt.Errorf("%w: must be an array of operations", ErrInvalidPatch)
+	}
+	for i, op := range patch {
+		switch op.Op {
+		case "add", "replace", "test":
+			//an explicit null is a value, a missing one isnt
+			if op.Value == nil {
+				return nil, fmt.Errorf("%w: operation %d (%s) is missing a value", ErrInvalidPatch, i, op.Op)
+			}
+		case "remove":
+		default:
+			return nil, fmt.Errorf("%w: operation %d has unsupported op %q", ErrInvalidPatch, i, op.Op)
+		}
+		_, err := parsePointer(op.Path)
+		if err != nil {
+			return nil, fmt.Errorf("%w: operation %d: %v", ErrInvalidPatch, i, err)
+		}
+	}
+	return patch, nil
+}
+
+// Apply runs the patch against doc and returns the result, doc itself is left alone
+func (p Patch) Apply(doc []byte) ([]byte, error) {
+	root, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+	for i, op := range p {
+		root, err = op.apply(root)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	return json.Marshal(root)
+}
+
+func (op Operation) apply(root interface{}) (interface{}, error) {
+	tokens, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	var value interface{}
+	if op.Value != nil {
+		value, err = decode(op.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+		}
+	}
+
+	switch op.Op {
+	case "test":
+		current, err := resolve(root, tokens)
+		if err != nil {
+			return nil, err
+		}
+		if !equal(current, value) {
+			return nil, ErrTestFailed
+		}
+		return root, nil
+	case "add":
+		if len(tokens) == 0 {
+			return value, nil
+		}
+		return update(root, tokens, func(parent interface{}, key string) (interface{}, error) {
+			switch parent := parent.(type) {
+			case map[string]interface{}:
+				parent[key] = value
+				return parent, nil
+			case []interface{}:
+				if key == "-" {
+					return append(parent, value), nil
+				}
+				i, err := arrayIndex(key, len(parent)+1)
+				if err != nil {
+					return nil, err
+				}
+				parent = append(parent, nil)
+				copy(parent[i+1:], parent[i:])
+				parent[i] = value
+				return parent, nil
+			}
+			return nil, ErrPathNotFound
+		})
+	case "remove", "replace":
+		if len(tokens) == 0 {
+			if op.Op == "remove" {
+				return nil, fmt.Errorf("%w: cannot remove the whole document", ErrInvalidPatch)
+			}
+			return value, nil
+		}
+		return update(root, tokens, func(parent interface{}, key string) (interface{}, error) {
+			switch parent := parent.(type) {
+			case map[string]interface{}:
+				if _, ok := parent[key]; !ok {
+					return nil, ErrPathNotFound
+				}
+				if op.Op == "remove" {
+					delete(parent, key)
+				} else {
+					parent[key] = value
+				}
+				return parent, nil
+			case []interface{}:
+				i, err := arrayIndex(key, len(parent))
+				if err != nil {
+					return nil, err
+				}
+				if op.Op == "remove" {
+					return append(parent[:i], parent[i+1:]...), nil
+				}
+				parent[i] = value
+				return parent, nil
+			}
+			return nil, ErrPathNotFound
+		})
+	}
+	return nil, fmt.Errorf("%w: unsupported op %q", ErrInvalidPatch, op.Op)
+}
+
+// update walks down to the container holding the last token and swaps it for
+// whatever fn returns, arrays can change length so every level gets written back
+func update(node interface{}, tokens []string, fn func(parent interface{}, key string) (interface{}, error)) (interface{}, error) {
+	if len(tokens) == 1 {
+		return fn(node, tokens[0])
+	}
+	child, err := resolve(node, tokens[:1])
+	if err != nil {
+		return nil, err
+	}
+	child, err = update(child, tokens[1:], fn)
+	if err != nil {
+		return nil, err
+	}
+	switch node := node.(type) {
+	case map[string]interface{}:
+		node[tokens[0]] = child
+	case []interface{}:
+		i, _ := arrayIndex(tokens[0], len(node))
+		node[i] = child
+	}
+	return node, nil
+}
+
+// resolve returns the value at tokens, ErrPathNotFound if there isnt one
+func resolve(node interface{}, tokens []string) (interface{}, error) {
+	for _, token := range tokens {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			child, ok := n[token]
+			if !ok {
+				return nil, ErrPathNotFound
+			}
+			node = child
+		case []interface{}:
+			i, err := arrayIndex(token, len(n))
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, ErrPathNotFound
+		}
+	}
+	return node, nil
+}
+
+// arrayIndex parses an array index token, it must be below max. No leading zeros
+// and no "-" here, add handles that one itself
+func arrayIndex(token string, max int) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') || strings.TrimLeft(token, "0123456789") != "" {
+		return 0, ErrPathNotFound
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i >= max {
+		return 0, ErrPathNotFound
+	}
+	return i, nil
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into unescaped tokens, "" is the
+// whole document
+func parsePointer(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("path %q must start with /", path)
+	}
+	tokens := strings.Split(path[1:], "/")
+	for i, token := range tokens {
+		//~1 first so ~01 comes out as ~1 and not /
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// decode keeps numbers as json.Number so they go back out exactly as they came in
+func decode(b []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v interface{}
+	err := dec.Decode(&v)
+	if err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("must contain a single JSON value")
+	}
+	return v, nil
+}
+
+// equal compares decoded values, numbers by value so 1 and 1.0 match
+func equal(a, b interface{}) bool {
+	switch a := a.(type) {
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for key, value := range a {
+			other, ok := b[key]
+			if !ok || !equal(value, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equal(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		if a == b {
+			return true
+		}
+		x, errA := a.Float64()
+		y, errB := b.Float64()
+		return errA == nil && errB == nil && x == y
+	default:
+		return a == b
+	}
+}
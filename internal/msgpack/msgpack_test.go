@@ -0,0 +1,182 @@
+package msgpack
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// encodings from the MessagePack spec, FromJSON must pick the smallest format
+func TestFromJSON(t *testing.T) {
+	tests := []struct {
+		js   string
+		want string
+	}{
+		//the example on msgpack.org
+		{`{"compact":true,"schema":0}`, "82 a7 636f6d70616374 c3 a6 736368656d61 00"},
+		{`null`, "c0"},
+		{`false`, "c2"},
+		{`true`, "c3"},
+		{`0`, "00"},
+		{`127`, "7f"},
+		{`128`, "cc 80"},
+		{`255`, "cc ff"},
+		{`256`, "cd 0100"},
+		{`65535`, "cd ffff"},
+		{`65536`, "ce 00010000"},
+		{`4294967296`, "cf 0000000100000000"},
+		{`18446744073709551615`, "cf ffffffffffffffff"},
+		{`-1`, "ff"},
+		{`-32`, "e0"},
+		{`-33`, "d0 df"},
+		{`-128`, "d0 80"},
+		{`-129`, "d1 ff7f"},
+		{`-32769`, "d2 ffff7fff"},
+		{`-2147483649`, "d3 ffffffff7fffffff"},
+		{`1.5`, "cb 3ff8000000000000"},
+		{`""`, "a0"},
+		{`"a"`, "a1 61"},
+		{`[]`, "90"},
+		{`[1,[2]]`, "92 01 91 02"},
+		{`{}`, "80"},
+		//keys come out sorted
+		{`{"b":1,"a":2}`, "82 a1 61 02 a1 62 01"},
+	}
+	for _, tt := range tests {
+		got, err := FromJSON([]byte(tt.js))
+		if err != nil {
+			t.Errorf("FromJSON(%s): %v", tt.js, err)
+			continue
+		}
+		if want := mustHex(t, tt.want); !bytes.Equal(got, want) {
+			t.Errorf("FromJSON(%s) = % x, want % x", tt.js, got, want)
+		}
+	}
+}
+
+func TestLengthFormats(t *testing.T) {
+	tests := []struct {
+		n      int
+		prefix string
+	}{
+		{31, "bf"},
+		{32, "d9 20"},
+		{255, "d9 ff"},
+		{256, "da 0100"},
+		{65536, "db 00010000"},
+	}
+	for _, tt := range tests {
+		js, _ := json.Marshal(strings.Repeat("x", tt.n))
+		got, err := FromJSON(js)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := mustHex(t, tt.prefix); !bytes.HasPrefix(got, want) || len(got) != len(want)+tt.n {
+			t.Errorf("string of %d starts % x, want % x", tt.n, got[:min(len(got), 5)], want)
+		}
+	}
+
+	//arrays and maps have no 8 bit form
+	items := make([]int, 16)
+	js, _ := json.Marshal(items)
+	got, _ := FromJSON(js)
+	if !bytes.HasPrefix(got, mustHex(t, "dc 0010")) {
+		t.Errorf("array of 16 starts % x, want dc 00 10", got[:3])
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	docs := []string{
+		`{"movie":{"id":1,"title":"Casablanca","year":1942,"runtime":"102 mins","genres":["drama","romance"],"version":1}}`,
+		`{"metadata":{"current_page":1,"page_size":20},"movies":[]}`,
+		`[null,true,false,-1,0,1.25,"",{"nested":[[],[{}]]}]`,
+		`{"unicode":"日本語 ✓","escaped":"a\"b\\c\n"}`,
+		`18446744073709551615`,
+		`-9223372036854775808`,
+	}
+	for _, doc := range docs {
+		b, err := FromJSON([]byte(doc))
+		if err != nil {
+			t.Errorf("FromJSON(%s): %v", doc, err)
+			continue
+		}
+		got, err := ToJSON(b)
+		if err != nil {
+			t.Errorf("ToJSON(FromJSON(%s)): %v", doc, err)
+			continue
+		}
+		var want, have interface{}
+		json.Unmarshal([]byte(doc), &want)
+		json.Unmarshal(got, &have)
+		wantJS, _ := json.Marshal(want)
+		haveJS, _ := json.Marshal(have)
+		if !bytes.Equal(wantJS, haveJS) {
+			t.Errorf("round trip of %s gave %s", doc, got)
+		}
+	}
+}
+
+// ToJSON reads formats FromJSON never writes, like float32 and 8 bit strings
+func TestToJSONFormats(t *testing.T) {
+	tests := []struct {
+		mp   string
+		want string
+	}{
+		{"ca 3fc00000", `1.5`},
+		{"d9 01 61", `"a"`},
+		{"cc 01", `1`},
+		{"d0 01", `1`},
+		{"c4 03 010203", `"AQID"`}, //bin comes out as base64
+		{"de 0001 a1 61 01", `{"a":1}`},
+		{"dd 00000001 c0", `[null]`},
+	}
+	for _, tt := range tests {
+		got, err := ToJSON(mustHex(t, tt.mp))
+		if err != nil {
+			t.Errorf("ToJSON(%s): %v", tt.mp, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("ToJSON(%s) = %s, want %s", tt.mp, got, tt.want)
+		}
+	}
+}
+
+func TestToJSONInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		mp   []byte
+	}{
+		{"empty", nil},
+		{"truncated string", mustHex(t, "a5 6162")},
+		{"truncated int", mustHex(t, "cd 01")},
+		{"trailing data", mustHex(t, "01 02")},
+		{"non string key", mustHex(t, "81 01 02")},
+		{"ext type", mustHex(t, "d4 01 00")},
+		{"timestamp", mustHex(t, "d6 ff 00000000")},
+		{"never used", mustHex(t, "c1")},
+		{"huge array length", mustHex(t, "dd ffffffff")},
+		{"huge map length", mustHex(t, "df ffffffff")},
+		{"too deep", bytes.Repeat([]byte{0x91}, maxDepth+2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ToJSON(tt.mp)
+			if !errors.Is(err, ErrInvalid) {
+				t.Errorf("got %v, want ErrInvalid", err)
+			}
+		})
+	}
+}
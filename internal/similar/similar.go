@@ -0,0 +1,296 @@
+// Package similar scores movies against each other for "more like this" lists and
+// per user recommendations. The model is a snapshot of every movie and rating held
+// in memory and swapped out whenever it is reloaded, so lookups never touch the
+// database
+package similar
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// how much each signal counts towards a score, they add up to 1
+const (
+	genreWeight  = 0.45
+	peopleWeight = 0.2
+	ratingWeight = 0.2
+	yearWeight   = 0.15
+	//movies this many years or more apart get nothing for year proximity
+	yearWindow = 25
+	//sharing this many cast or crew members counts as fully similar on people
+	peopleSaturation = 3
+	//ratings at or above this count as liking a movie for co-occurrence
+	likedRating = 7
+	//a movie on the watchlist counts like a rating this strong
+	watchlistWeight = 0.5
+)
+
+// Movie is what the model needs to know about a movie. People is the cast and
+// crew names, any case
+type Movie struct {
+	ID     int64
+	Title  string
+	Year   int32
+	Genres []string
+	People []string
+}
+
+// Rating is one users rating of a movie, out of 10
+type Rating struct {
+	UserID  int64
+	MovieID int64
+	Rating  int
+}
+
+// Match is a scored similar movie, score is between 0 and 1
+type Match struct {
+	ID     int64    `json:"id"`
+	Title  string   `json:"title"`
+	Year   int32    `json:"year,omitempty"`
+	Genres []string `json:"genres,omitempty"`
+	Score  float64  `json:"score"`
+}
+
+type entry struct {
+	movie  Movie
+	genres map[string]struct{}
+	people map[string]struct{}
+}
+
+// Model is safe to use from many goroutines
+type Model struct {
+	mu      sync.RWMutex
+	entries map[int64]*entry
+	likers  map[int64][]int64 //movie to the users that liked it
+	likes   map[int64][]int64 //user to the movies they liked
+}
+
+func New() *Model {
+	return &Model{}
+}
+
+// Load replaces the snapshot. Ratings for movies not in movies are ignored
+func (m *Model) Load(movies []Movie, ratings []Rating) {
+	entries := make(map[int64]*entry, len(movies))
+	for _, movie := range movies {
+		entries[movie.ID] = &entry{movie: movie, genres: genreSet(movie.Genres), people: peopleSet(movie.People)}
+	}
+
+	likers := make(map[int64][]int64)
+	likes := make(map[int64][]int64)
+	for _, r := range ratings {
+		if _, ok := entries[r.MovieID]; !ok || r.Rating < likedRating {
+			continue
+		}
+		likers[r.MovieID] = append(likers[r.MovieID], r.UserID)
+		likes[r.UserID] = append(likes[r.UserID], r.MovieID)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = entries
+	m.likers = likers
+	m.likes = likes
+}
+
+// Remove drops a movie until the next Load, for movies moved to the trash. A restored
+// movie comes back with the next Load
+func (m *Model) Remove(id int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, id)
+}
+
+// Similar returns the n best matches for target, best first. target doesnt have to
+// be in the snapshot, so movies added since the last Load still get results.
+// Only movies sharing a genre, a person or fans with target are considered
+func (m *Model) Similar(target Movie, n int) []Match {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	scores := m.scores(target)
+	matches := make([]Match, 0, len(scores))
+	for id, score := range scores {
+		matches = append(matches, m.match(id, score))
+	}
+	return best(matches, n)
+}
+
+// Seed is a movie a user has shown an opinion on. Weight is how much it pulls the
+// recommendations towards movies like it, negative pushes them away
+type Seed struct {
+	ID     int64
+	Weight float64
+}
+
+// RatingSeed turns a rating out of 10 into a seed, 10 is 1 and 1 is -1
+func RatingSeed(movieID int64, rating int) Seed {
+	return Seed{ID: movieID, Weight: (float64(rating) - 5.5) / 4.5}
+}
+
+// WatchlistSeed is a seed for a movie on the users watchlist
+func WatchlistSeed(movieID int64) Seed {
+	return Seed{ID: movieID, Weight: watchlistWeight}
+}
+
+// Recommend blends the similar scores of every seed, weighted, and returns the n
+// best movies that arent seeds themselves. Seeds missing from the snapshot are
+// skipped, a user with no positive seeds gets nothing
+func (m *Model) Recommend(seeds []Seed, n int) []Match {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	exclude := make(map[int64]bool, len(seeds))
+	for _, seed := range seeds {
+		exclude[seed.ID] = true
+	}
+
+	totals := make(map[int64]float64)
+	positive := 0.0
+	for _, seed := range seeds {
+		e, ok := m.entries[seed.ID]
+		if !ok || seed.Weight == 0 {
+			continue
+		}
+		if seed.Weight > 0 {
+			positive += seed.Weight
+		}
+		for id, score := range m.scores(e.movie) {
+			if !exclude[id] {
+				totals[id] += seed.Weight * score
+			}
+		}
+	}
+	if positive == 0 {
+		return []Match{}
+	}
+
+	matches := make([]Match, 0, len(totals))
+	for id, total := range totals {
+		if total <= 0 {
+			continue
+		}
+		matches = append(matches, m.match(id, total/positive))
+	}
+	return best(matches, n)
+}
+
+// scores is every candidates score against target, m.mu must be held
+func (m *Model) scores(target Movie) map[int64]float64 {
+	targetGenres := genreSet(target.Genres)
+	targetPeople := peopleSet(target.People)
+	coLiked := m.coLiked(target.ID)
+
+	scores := make(map[int64]float64)
+	for id, e := range m.entries {
+		if id == target.ID {
+			continue
+		}
+		genres := jaccard(targetGenres, e.genres)
+		people := math.Min(1, float64(shared(targetPeople, e.people))/peopleSaturation)
+		ratings := coLiked[id]
+		if genres == 0 && people == 0 && ratings == 0 {
+			continue
+		}
+		scores[id] = genreWeight*genres + peopleWeight*people + ratingWeight*ratings +
+			yearWeight*yearProximity(target.Year, e.movie.Year)
+	}
+	return scores
+}
+
+// coLiked is the cosine similarity between the users who liked id and the users who
+// liked each other movie, only movies with at least one fan in common are in it
+func (m *Model) coLiked(id int64) map[int64]float64 {
+	fans := m.likers[id]
+	if len(fans) == 0 {
+		return nil
+	}
+	counts := make(map[int64]int)
+	for _, user := range fans {
+		for _, other := range m.likes[user] {
+			if other != id {
+				counts[other]++
+			}
+		}
+	}
+	similarity := make(map[int64]float64, len(counts))
+	for other, count := range counts {
+		similarity[other] = float64(count) / math.Sqrt(float64(len(fans)*len(m.likers[other])))
+	}
+	return similarity
+}
+
+func (m *Model) match(id int64, score float64) Match {
+	movie := m.entries[id].movie
+	return Match{
+		ID:     movie.ID,
+		Title:  movie.Title,
+		Year:   movie.Year,
+		Genres: movie.Genres,
+		Score:  math.Round(score*1000) / 1000,
+	}
+}
+
+// best sorts matches best first, ties by id, and keeps the first n
+func best(matches []Match, n int) []Match {
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	if len(matches) > n {
+		matches = matches[:n]
+	}
+	return matches
+}
+
+func genreSet(genres []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(genres))
+	for _, g := range genres {
+		set[g] = struct{}{}
+	}
+	return set
+}
+
+// peopleSet ignores case and surrounding spaces, credits are typed in by hand
+func peopleSet(people []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(people))
+	for _, p := range people {
+		if p = strings.ToLower(strings.TrimSpace(p)); p != "" {
+			set[p] = struct{}{}
+		}
+	}
+	return set
+}
+
+func shared(a, b map[string]struct{}) int {
+	n := 0
+	for k := range a {
+		if _, ok := b[k]; ok {
+			n++
+		}
+	}
+	return n
+}
+
+// jaccard is the size of the intersection over the size of the union
+func jaccard(a, b map[string]struct{}) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	n := shared(a, b)
+	return float64(n) / float64(len(a)+len(b)-n)
+}
+
+// yearProximity is 1 for the same year falling to 0 at yearWindow apart,
+// and 0 when either year is unknown
+func yearProximity(a, b int32) float64 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	diff := math.Abs(float64(a - b))
+	return math.Max(0, 1-diff/yearWindow)
+}
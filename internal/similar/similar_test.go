@@ -0,0 +1,164 @@
+package similar
+
+import (
+	"testing"
+)
+
+func ids(matches []Match) []int64 {
+	out := make([]int64, len(matches))
+	for i, m := range matches {
+		out[i] = m.ID
+	}
+	return out
+}
+
+func equalIDs(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func testModel() *Model {
+	m := New()
+	m.Load([]Movie{
+		{ID: 1, Title: "Alien", Year: 1979, Genres: []string{"horror", "sci-fi"}, People: []string{"Ridley Scott", "Sigourney Weaver"}},
+		{ID: 2, Title: "Aliens", Year: 1986, Genres: []string{"action", "sci-fi"}, People: []string{"James Cameron", " sigourney weaver "}},
+		{ID: 3, Title: "Blade Runner", Year: 1982, Genres: []string{"sci-fi"}, People: []string{"Ridley Scott", "Harrison Ford"}},
+		{ID: 4, Title: "The Thing", Year: 1982, Genres: []string{"horror", "sci-fi"}},
+		{ID: 5, Title: "Notting Hill", Year: 1999, Genres: []string{"romance"}},
+		{ID: 6, Title: "Love Actually", Year: 2003, Genres: []string{"romance", "comedy"}},
+	}, []Rating{
+		{UserID: 10, MovieID: 5, Rating: 9},
+		{UserID: 10, MovieID: 1, Rating: 8},
+		{UserID: 11, MovieID: 5, Rating: 8},
+		{UserID: 11, MovieID: 1, Rating: 10},
+		{UserID: 12, MovieID: 6, Rating: 3}, //not liked, no signal
+		{UserID: 12, MovieID: 1, Rating: 9},
+		{UserID: 13, MovieID: 99, Rating: 9}, //movie not in the snapshot
+	})
+	return m
+}
+
+func TestSimilar(t *testing.T) {
+	m := testModel()
+
+	tests := []struct {
+		name   string
+		target Movie
+		n      int
+		want   []int64
+	}{
+		{
+			//same genres and year beat shared people, fans link Notting Hill
+			name:   "all signals",
+			target: Movie{ID: 1, Year: 1979, Genres: []string{"horror", "sci-fi"}, People: []string{"Ridley Scott", "Sigourney Weaver"}},
+			n:      10,
+			want:   []int64{4, 3, 2, 5},
+		},
+		{
+			name:   "limit",
+			target: Movie{ID: 1, Year: 1979, Genres: []string{"horror", "sci-fi"}, People: []string{"Ridley Scott", "Sigourney Weaver"}},
+			n:      2,
+			want:   []int64{4, 3},
+		},
+		{
+			name:   "not in the snapshot",
+			target: Movie{ID: 100, Year: 2001, Genres: []string{"comedy"}},
+			n:      10,
+			want:   []int64{6},
+		},
+		{
+			name:   "nothing in common",
+			target: Movie{ID: 100, Year: 2001, Genres: []string{"western"}},
+			n:      10,
+			want:   []int64{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := m.Similar(tt.target, tt.n)
+			if !equalIDs(ids(got), tt.want) {
+				t.Errorf("Similar = %v, want %v", ids(got), tt.want)
+			}
+			for _, match := range got {
+				if match.Score <= 0 || match.Score > 1 {
+					t.Errorf("movie %d scored %v, want (0, 1]", match.ID, match.Score)
+				}
+			}
+		})
+	}
+}
+
+func TestRecommend(t *testing.T) {
+	m := testModel()
+
+	tests := []struct {
+		name  string
+		seeds []Seed
+		want  []int64
+	}{
+		{
+			name:  "liked a movie",
+			seeds: []Seed{RatingSeed(3, 10)},
+			want:  []int64{1, 4, 2},
+		},
+		{
+			//hating Alien pushes its look-alikes below zero
+			name:  "disliked outweighs",
+			seeds: []Seed{RatingSeed(3, 10), RatingSeed(1, 1)},
+			want:  []int64{2},
+		},
+		{
+			name:  "watchlist only",
+			seeds: []Seed{WatchlistSeed(6)},
+			want:  []int64{5},
+		},
+		{
+			name:  "only dislikes",
+			seeds: []Seed{RatingSeed(1, 2)},
+			want:  []int64{},
+		},
+		{
+			name:  "seed not in the snapshot",
+			seeds: []Seed{RatingSeed(100, 10)},
+			want:  []int64{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := m.Recommend(tt.seeds, 10)
+			if !equalIDs(ids(got), tt.want) {
+				t.Errorf("Recommend = %v, want %v", ids(got), tt.want)
+			}
+			for _, match := range got {
+				for _, seed := range tt.seeds {
+					if match.ID == seed.ID {
+						t.Errorf("recommended seed %d", seed.ID)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRemove(t *testing.T) {
+	m := testModel()
+	m.Remove(4)
+
+	target := Movie{ID: 1, Year: 1979, Genres: []string{"horror", "sci-fi"}}
+	for _, match := range m.Similar(target, 10) {
+		if match.ID == 4 {
+			t.Fatal("removed movie still matched")
+		}
+	}
+	//a removed seed is skipped like one missing from the snapshot
+	if got := m.Recommend([]Seed{RatingSeed(4, 10)}, 10); len(got) != 0 {
+		t.Errorf("Recommend from a removed seed = %v, want none", ids(got))
+	}
+}
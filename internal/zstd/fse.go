@@ -0,0 +1,236 @@
+package zstd
+
+import (
+	"math"
+	"math/bits"
+)
+
+// fseTable encodes symbols against a normalized distribution. The states are laid
+// out the same way the decoder builds its table
+type fseTable struct {
+	rle        bool //one symbol, nothing goes in the bitstream
+	log        uint
+	stateTable []uint16
+	symbols    []fseSymbol
+}
+
+type fseSymbol struct {
+	deltaNbBits    uint32
+	deltaFindState int32
+}
+
+func newFSETable(norm []int16, log uint) *fseTable {
+	size := 1 << log
+	t := &fseTable{log: log, stateTable: make([]uint16, size), symbols: make([]fseSymbol, len(norm))}
+
+	//probabilities below 1 get a cell each from the top, the rest are spread
+	cells := make([]int, size)
+	cumul := make([]int, len(norm)+1)
+	high := size - 1
+	for s, p := range norm {
+		if p == -1 {
+			cumul[s+1] = cumul[s] + 1
+			cells[high] = s
+			high--
+		} else {
+			cumul[s+1] = cumul[s] + int(p)
+		}
+	}
+	step := size>>1 + size>>3 + 3
+	pos := 0
+	for s, p := range norm {
+		for i := 0; i < int(p); i++ {
+			cells[pos] = s
+			pos = (pos + step) & (size - 1)
+			for pos > high {
+				pos = (pos + step) & (size - 1)
+			}
+		}
+	}
+
+	next := append([]int(nil), cumul...)
+	for u, s := range cells {
+		t.stateTable[next[s]] = uint16(size + u)
+		next[s]++
+	}
+
+	for s, p := range norm {
+		switch {
+		case p == 0:
+		case p == -1 || p == 1:
+			t.symbols[s] = fseSymbol{
+				deltaNbBits:    uint32(log<<16) - uint32(size),
+				deltaFindState: int32(cumul[s] - 1),
+			}
+		default:
+			maxBitsOut := log - uint(bits.Len32(uint32(p-1))-1)
+			minStatePlus := uint32(p) << maxBitsOut
+			t.symbols[s] = fseSymbol{
+				deltaNbBits:    uint32(maxBitsOut<<16) - minStatePlus,
+				deltaFindState: int32(cumul[s] - int(p)),
+			}
+		}
+	}
+	return t
+}
+
+// init is the state the first symbol encoded (the last one decoded) starts from
+func (t *fseTable) init(sym uint8) uint32 {
+	if t.rle {
+		return 0
+	}
+	tt := t.symbols[sym]
+	nbBits := (tt.deltaNbBits + 1<<15) >> 16
+	value := nbBits<<16 - tt.deltaNbBits
+	return uint32(t.stateTable[int32(value>>nbBits)+tt.deltaFindState])
+}
+
+func (t *fseTable) encode(bw *bitWriter, state *uint32, sym uint8) {
+	if t.rle {
+		return
+	}
+	tt := t.symbols[sym]
+	nbBits := (*state + tt.deltaNbBits) >> 16
+	bw.add(uint64(*state), uint(nbBits))
+	*state = uint32(t.stateTable[int32(*state>>nbBits)+tt.deltaFindState])
+}
+
+// flush writes the final state, which the decoder reads first
+func (t *fseTable) flush(bw *bitWriter, state uint32) {
+	if t.rle {
+		return
+	}
+	bw.add(uint64(state), t.log)
+}
+
+// table modes in the sequences section header
+const (
+	modePredefined = 0
+	modeRLE        = 1
+	modeCompressed = 2
+)
+
+// chooseTable picks the cheapest way to code counts: the predefined table, a single
+// repeated symbol, or a table of our own written out in header
+func chooseTable(counts []int, total int, predefined *fseTable, predefinedNorm []int16, maxLog uint, header []byte) (*fseTable, int, []byte) {
+	distinct, last := 0, 0
+	for s, c := range counts {
+		if c > 0 {
+			distinct++
+			last = s
+		}
+	}
+	if distinct == 1 {
+		return &fseTable{rle: true}, modeRLE, append(header, byte(last))
+	}
+
+	log := min(maxLog, uint(bits.Len(uint(total))-1))
+	log = max(log, 5, uint(bits.Len(uint(distinct)))+1)
+	norm := normalize(counts[:last+1], total, log)
+	described := writeNCount(header, norm, log)
+
+	predefinedCost, ok := cost(counts, predefinedNorm, predefined.log)
+	customCost, _ := cost(counts, norm, log)
+	if ok && predefinedCost <= customCost+float64(8*(len(described)-len(header))) {
+		return predefined, modePredefined, header
+	}
+	return newFSETable(norm, log), modeCompressed, described
+}
+
+// cost is roughly how many bits coding counts against norm takes, ok is false if
+// norm cant code every symbol
+func cost(counts []int, norm []int16, log uint) (float64, bool) {
+	bits := 0.0
+	for s, c := range counts {
+		if c == 0 {
+			continue
+		}
+		if s >= len(norm) || norm[s] == 0 {
+			return 0, false
+		}
+		bits += float64(c) * (float64(log) - math.Log2(float64(max(norm[s], 1))))
+	}
+	return bits, true
+}
+
+// normalize scales counts so they add up to 1<<log, every symbol used keeps at
+// least 1
+func normalize(counts []int, total int, log uint) []int16 {
+	size := 1 << log
+	norm := make([]int16, len(counts))
+	sum, largest := 0, 0
+	for s, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := max(1, (c*size+total/2)/total)
+		norm[s] = int16(p)
+		sum += p
+		if c > counts[largest] {
+			largest = s
+		}
+	}
+	norm[largest] += int16(size - sum)
+	//rounding up the rare symbols can leave the largest short, take from the biggest
+	for norm[largest] < 1 {
+		biggest := 0
+		for s := range norm {
+			if s != largest && norm[s] > norm[biggest] {
+				biggest = s
+			}
+		}
+		norm[biggest]--
+		norm[largest]++
+	}
+	return norm
+}
+
+// writeNCount appends the table description for norm, RFC 8878 section 4.1.1
+func writeNCount(dst []byte, norm []int16, log uint) []byte {
+	var bw bitWriter
+	bw.reset(dst)
+	bw.add(uint64(log-5), 4)
+
+	size := 1 << log
+	remaining := size + 1
+	threshold := size
+	nbBits := log + 1
+	previousIs0 := false
+	for s := 0; s < len(norm) && remaining > 1; {
+		if previousIs0 {
+			//a run of zero probabilities is written as repeat flags
+			start := s
+			for s < len(norm) && norm[s] == 0 {
+				s++
+			}
+			for s >= start+24 {
+				start += 24
+				bw.add(0xFFFF, 16)
+			}
+			for s >= start+3 {
+				start += 3
+				bw.add(3, 2)
+			}
+			bw.add(uint64(s-start), 2)
+		}
+		count := int(norm[s])
+		s++
+		limit := 2*threshold - 1 - remaining
+		remaining -= max(count, -count)
+		count++
+		if count >= threshold {
+			count += limit
+		}
+		if count < limit {
+			bw.add(uint64(count), nbBits-1)
+		} else {
+			bw.add(uint64(count), nbBits)
+		}
+		previousIs0 = count == 1
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+	return bw.pad()
+}
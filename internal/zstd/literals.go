@@ -0,0 +1,226 @@
+package zstd
+
+import (
+	"sort"
+)
+
+const (
+	//Huffman codes the format allows are at most this long
+	maxHuffBits = 11
+	//fewer literals than this arent worth a Huffman table
+	minHuffLiterals = 64
+	//the weights are written directly, 4 bits each, which only covers this many
+	//symbols. Non ASCII text falls back to raw literals
+	maxDirectSymbol = 128
+
+	literalsRaw        = 0
+	literalsCompressed = 2
+)
+
+func (e *encoder) appendLiterals(dst, lits []byte) []byte {
+	if len(lits) >= minHuffLiterals {
+		if out, ok := e.huff.appendCompressed(dst, lits); ok {
+			return out
+		}
+	}
+	return appendRawLiterals(dst, lits)
+}
+
+func appendRawLiterals(dst, lits []byte) []byte {
+	n := len(lits)
+	switch {
+	case n < 1<<5:
+		dst = append(dst, byte(literalsRaw|n<<3))
+	case n < 1<<12:
+		dst = append(dst, byte(literalsRaw|1<<2|n<<4), byte(n>>4))
+	default:
+		dst = append(dst, byte(literalsRaw|3<<2|n<<4), byte(n>>4), byte(n>>12))
+	}
+	return append(dst, lits...)
+}
+
+type huffEncoder struct {
+	counts  [256]int
+	lengths [256]uint8
+	codes   [256]uint16
+	nodes   []huffNode
+	bits    bitWriter
+}
+
+type huffNode struct {
+	freq   int
+	parent int
+	symbol int
+}
+
+// appendCompressed Huffman codes lits, ok is false when it cant or it wouldnt be
+// any smaller
+func (h *huffEncoder) appendCompressed(dst, lits []byte) ([]byte, bool) {
+	h.counts = [256]int{}
+	for _, b := range lits {
+		h.counts[b]++
+	}
+	maxSym, distinct := 0, 0
+	for s, c := range h.counts {
+		if c > 0 {
+			maxSym = s
+			distinct++
+		}
+	}
+	if maxSym > maxDirectSymbol || distinct < 2 {
+		return dst, false
+	}
+	maxLen := h.buildCodes(maxSym)
+
+	//literals section header, sized for the regenerated size. The compressed
+	//size is never bigger than that or we give up
+	n := len(lits)
+	start := len(dst)
+	var headerLen, sizeFormat, sizeBits int
+	switch {
+	case n < 1<<10:
+		headerLen, sizeFormat, sizeBits = 3, 0, 10 //single stream
+	case n < 1<<14:
+		headerLen, sizeFormat, sizeBits = 4, 2, 14
+	default:
+		headerLen, sizeFormat, sizeBits = 5, 3, 18
+	}
+	dst = append(dst, make([]byte, headerLen)...)
+
+	//tree description, a weight per symbol below maxSym whose own weight is implied
+	dst = append(dst, byte(127+maxSym))
+	for s := 0; s < maxSym; s += 2 {
+		b := h.weight(s, maxLen) << 4
+		if s+1 < maxSym {
+			b |= h.weight(s+1, maxLen)
+		}
+		dst = append(dst, b)
+	}
+
+	if sizeFormat == 0 {
+		dst = h.appendStream(dst, lits)
+	} else {
+		//four streams behind a jump table of the first three sizes
+		jump := len(dst)
+		dst = append(dst, 0, 0, 0, 0, 0, 0)
+		seg := (n + 3) / 4
+		for i := 0; i < 4; i++ {
+			from := len(dst)
+			dst = h.appendStream(dst, lits[min(i*seg, n):min((i+1)*seg, n)])
+			if i < 3 {
+				size := len(dst) - from
+				dst[jump+2*i] = byte(size)
+				dst[jump+2*i+1] = byte(size >> 8)
+			}
+		}
+	}
+
+	compressed := len(dst) - start - headerLen
+	if compressed >= n {
+		return dst[:start], false
+	}
+	header := uint64(literalsCompressed) | uint64(sizeFormat)<<2 | uint64(n)<<4 | uint64(compressed)<<(4+sizeBits)
+	for i := 0; i < headerLen; i++ {
+		dst[start+i] = byte(header >> (8 * i))
+	}
+	return dst, true
+}
+
+// weight is what the tree description stores for a symbol, 0 for unused ones
+func (h *huffEncoder) weight(s, maxLen int) byte {
+	if h.lengths[s] == 0 {
+		return 0
+	}
+	return byte(maxLen + 1 - int(h.lengths[s]))
+}
+
+// appendStream writes src backwards so the decoder gets it front to back
+func (h *huffEncoder) appendStream(dst, src []byte) []byte {
+	h.bits.reset(dst)
+	for i := len(src) - 1; i >= 0; i-- {
+		s := src[i]
+		h.bits.add(uint64(h.codes[s]), uint(h.lengths[s]))
+	}
+	return h.bits.close()
+}
+
+// buildCodes sets the code lengths and codes for the counted symbols and returns
+// the longest length
+func (h *huffEncoder) buildCodes(maxSym int) int {
+	freqs := h.counts
+	for {
+		if maxLen := h.buildLengths(freqs[:maxSym+1]); maxLen <= maxHuffBits {
+			break
+		}
+		//too deep, flatten the counts and try again. Every count stays above 0
+		for s := range freqs {
+			freqs[s] = (freqs[s] + 1) / 2
+		}
+	}
+	return h.assignCodes(maxSym)
+}
+
+// assignCodes gives out canonical codes for the lengths the way the decoder
+// rebuilds them, longest codes first with ties by symbol, counting up
+func (h *huffEncoder) assignCodes(maxSym int) int {
+	symbols := make([]int, 0, maxSym+1)
+	maxLen := 0
+	for s := 0; s <= maxSym; s++ {
+		if h.lengths[s] > 0 {
+			symbols = append(symbols, s)
+			maxLen = max(maxLen, int(h.lengths[s]))
+		}
+	}
+	sort.SliceStable(symbols, func(i, j int) bool {
+		return h.lengths[symbols[i]] > h.lengths[symbols[j]]
+	})
+	next := 0
+	for _, s := range symbols {
+		shift := maxLen - int(h.lengths[s])
+		h.codes[s] = uint16(next >> shift)
+		next += 1 << shift
+	}
+	return maxLen
+}
+
+// buildLengths is plain Huffman over freqs, returning the longest code
+func (h *huffEncoder) buildLengths(freqs []int) int {
+	h.lengths = [256]uint8{}
+	h.nodes = h.nodes[:0]
+	for s, f := range freqs {
+		if f > 0 {
+			h.nodes = append(h.nodes, huffNode{freq: f, parent: -1, symbol: s})
+		}
+	}
+	sort.SliceStable(h.nodes, func(i, j int) bool { return h.nodes[i].freq < h.nodes[j].freq })
+
+	//two queues, the leaves sorted and the joined nodes which come out in order
+	leaves := len(h.nodes)
+	nextLeaf, nextJoined := 0, leaves
+	pick := func() int {
+		if nextLeaf < leaves && (nextJoined >= len(h.nodes) || h.nodes[nextLeaf].freq <= h.nodes[nextJoined].freq) {
+			nextLeaf++
+			return nextLeaf - 1
+		}
+		nextJoined++
+		return nextJoined - 1
+	}
+	for i := 1; i < leaves; i++ {
+		a, b := pick(), pick()
+		h.nodes = append(h.nodes, huffNode{freq: h.nodes[a].freq + h.nodes[b].freq, parent: -1, symbol: -1})
+		h.nodes[a].parent = len(h.nodes) - 1
+		h.nodes[b].parent = len(h.nodes) - 1
+	}
+
+	//depths from the root down, parents always come after their children
+	depth := make([]int, len(h.nodes))
+	maxLen := 0
+	for i := len(h.nodes) - 2; i >= 0; i-- {
+		depth[i] = depth[h.nodes[i].parent] + 1
+		if i < leaves {
+			h.lengths[h.nodes[i].symbol] = uint8(min(depth[i], 255))
+			maxLen = max(maxLen, depth[i])
+		}
+	}
+	return maxLen
+}
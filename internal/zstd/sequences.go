@@ -0,0 +1,316 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	hashLog  = 15
+	minMatch = 4
+	//how many earlier positions with the same hash get tried for a longer match
+	searchDepth = 16
+	//a match this long is good enough, stop looking
+	niceLength = 64
+)
+
+// the repeat offsets every frame starts with
+var initialReps = [3]uint32{1, 4, 8}
+
+// sequence is a run of literals followed by a copy of earlier output. offValue is
+// the offset as the format codes it, 1 to 3 are repeat offsets
+type sequence struct {
+	litLen   uint32
+	matchLen uint32
+	offValue uint32
+}
+
+// parse splits src into sequences and the literals they use. Matches come from a
+// short hash chain search and are only taken once the next position doesnt have a
+// better one. Literals after the last match end the block
+func (e *encoder) parse(src []byte) {
+	e.literals = e.literals[:0]
+	e.seqs = e.seqs[:0]
+	e.table = [1 << hashLog]int32{}
+	if len(e.chain) < len(src) {
+		e.chain = make([]int32, blockSize)
+	}
+
+	anchor := 0
+	for i := 0; i+minMatch <= len(src); {
+		cand, n := e.search(src, i, anchor)
+		if n == 0 {
+			//step faster through data that isnt matching
+			i += 1 + (i-anchor)>>7
+			continue
+		}
+		for i+1+minMatch <= len(src) {
+			nextCand, nextN := e.search(src, i+1, anchor)
+			//waiting costs a literal, so the later match has to win by a bit
+			if nextN == 0 || matchGain(nextN, i+1-nextCand) <= matchGain(n, i-cand)+4 {
+				break
+			}
+			i, cand, n = i+1, nextCand, nextN
+		}
+		for i > anchor && cand > 0 && src[i-1] == src[cand-1] {
+			i--
+			cand--
+			n++
+		}
+
+		litLen := uint32(i - anchor)
+		e.literals = append(e.literals, src[anchor:i]...)
+		e.seqs = append(e.seqs, sequence{litLen: litLen, matchLen: uint32(n), offValue: e.offsetValue(uint32(i-cand), litLen)})
+		for end := i + n; i+1 < end; {
+			i++
+			if i+minMatch <= len(src) {
+				e.insert(src, i)
+			}
+		}
+		i++
+		anchor = i
+	}
+	e.literals = append(e.literals, src[anchor:]...)
+}
+
+// search adds i to the hash chains and returns the best earlier match for it, n is
+// 0 if there isnt one. The repeat offsets are tried first, they are the cheapest
+// to code
+func (e *encoder) search(src []byte, i, anchor int) (cand, n int) {
+	cur := binary.LittleEndian.Uint32(src[i:])
+	next := int(e.insert(src, i))
+
+	gain := 0
+	for _, rep := range e.reps {
+		pos := i - int(rep)
+		if pos < 0 || binary.LittleEndian.Uint32(src[pos:]) != cur {
+			continue
+		}
+		length := matchLength(src, pos, i)
+		if g := matchGain(length, 1); g > gain {
+			cand, n, gain = pos, length, g
+		}
+	}
+	for depth := 0; next > 0 && depth < searchDepth; depth++ {
+		pos := next - 1
+		next = int(e.chain[pos])
+		//the chain only gets further back, so a match has to be longer to win
+		if binary.LittleEndian.Uint32(src[pos:]) != cur || (n > 0 && i+n < len(src) && src[pos+n] != src[i+n]) {
+			continue
+		}
+		length := matchLength(src, pos, i)
+		if g := matchGain(length, i-pos); g > gain {
+			cand, n, gain = pos, length, g
+		}
+		if n >= niceLength {
+			break
+		}
+	}
+	return cand, n
+}
+
+// matchGain weighs a match by its length less what its offset costs to code, a
+// close match can beat a longer one far back
+func matchGain(n, offset int) int {
+	return 4*n - bits.Len(uint(offset))
+}
+
+// insert puts position i at the head of its hash chain and returns the old head,
+// positions are stored plus one so 0 is empty
+func (e *encoder) insert(src []byte, i int) int32 {
+	h := hash4(binary.LittleEndian.Uint32(src[i:]))
+	prev := e.table[h]
+	e.chain[i] = prev
+	e.table[h] = int32(i + 1)
+	return prev
+}
+
+func matchLength(src []byte, from, i int) int {
+	n := 0
+	for i+n+8 <= len(src) {
+		diff := binary.LittleEndian.Uint64(src[from+n:]) ^ binary.LittleEndian.Uint64(src[i+n:])
+		if diff != 0 {
+			return n + bits.TrailingZeros64(diff)/8
+		}
+		n += 8
+	}
+	for i+n < len(src) && src[from+n] == src[i+n] {
+		n++
+	}
+	return n
+}
+
+// offsetValue codes offset and keeps the repeat offsets in step with the decoder,
+// RFC 8878 section 3.1.2.5. With no literals before the match the repeat values
+// shift by one and 3 means the last offset less one
+func (e *encoder) offsetValue(offset, litLen uint32) uint32 {
+	r := e.reps
+	switch {
+	case litLen > 0 && offset == r[0]:
+		return 1
+	case litLen > 0 && offset == r[1], litLen == 0 && offset == r[1]:
+		e.reps = [3]uint32{r[1], r[0], r[2]}
+		if litLen == 0 {
+			return 1
+		}
+		return 2
+	case offset == r[2]:
+		e.reps = [3]uint32{r[2], r[0], r[1]}
+		if litLen == 0 {
+			return 2
+		}
+		return 3
+	case litLen == 0 && offset == r[0]-1:
+		e.reps = [3]uint32{offset, r[0], r[1]}
+		return 3
+	}
+	e.reps = [3]uint32{offset, r[0], r[1]}
+	return offset + 3
+}
+
+func hash4(u uint32) uint32 {
+	return (u * 2654435761) >> (32 - hashLog)
+}
+
+// appendSequences writes the sequences section, each of the three codes gets
+// whichever table is cheapest for this block
+func (e *encoder) appendSequences(dst []byte, seqs []sequence) []byte {
+	n := len(seqs)
+	switch {
+	case n < 128:
+		dst = append(dst, byte(n))
+	case n < 0x7F00:
+		dst = append(dst, byte(n>>8+128), byte(n))
+	default:
+		dst = append(dst, 255, byte(n-0x7F00), byte((n-0x7F00)>>8))
+	}
+	if n == 0 {
+		return dst
+	}
+
+	e.codes = e.codes[:0]
+	var llCounts [len(llBase)]int
+	var mlCounts [len(mlBase)]int
+	var ofCounts [maxOffsetCode + 1]int
+	for _, s := range seqs {
+		c := seqCodes{ll: llCode(s.litLen), ml: mlCode(s.matchLen), of: ofCode(s.offValue)}
+		llCounts[c.ll]++
+		mlCounts[c.ml]++
+		ofCounts[c.of]++
+		e.codes = append(e.codes, c)
+	}
+
+	modes := len(dst)
+	dst = append(dst, 0)
+	llt, llMode, dst := chooseTable(llCounts[:], n, llTable, llDefault, 9, dst)
+	oft, ofMode, dst := chooseTable(ofCounts[:], n, ofTable, ofDefault, 8, dst)
+	mlt, mlMode, dst := chooseTable(mlCounts[:], n, mlTable, mlDefault, 9, dst)
+	dst[modes] = byte(llMode<<6 | ofMode<<4 | mlMode<<2)
+
+	//the decoder reads the stream backwards, so the last sequence goes first
+	bw := &e.bits
+	bw.reset(dst)
+	last := e.codes[n-1]
+	llState, mlState, ofState := llt.init(last.ll), mlt.init(last.ml), oft.init(last.of)
+	addExtraBits(bw, seqs[n-1], last)
+	for i := n - 2; i >= 0; i-- {
+		c := e.codes[i]
+		oft.encode(bw, &ofState, c.of)
+		mlt.encode(bw, &mlState, c.ml)
+		llt.encode(bw, &llState, c.ll)
+		addExtraBits(bw, seqs[i], c)
+	}
+	mlt.flush(bw, mlState)
+	oft.flush(bw, ofState)
+	llt.flush(bw, llState)
+	return bw.close()
+}
+
+type seqCodes struct {
+	ll, ml, of uint8
+}
+
+func addExtraBits(bw *bitWriter, s sequence, c seqCodes) {
+	bw.add(uint64(s.litLen-llBase[c.ll]), uint(llBits[c.ll]))
+	bw.add(uint64(s.matchLen-mlBase[c.ml]), uint(mlBits[c.ml]))
+	bw.add(uint64(s.offValue), uint(c.of))
+}
+
+// offsets never get past a block, so the codes stop well short of the 31 allowed
+const maxOffsetCode = 17
+
+func ofCode(offValue uint32) uint8 {
+	return uint8(bits.Len32(offValue) - 1)
+}
+
+// baselines and extra bits for the literal length and match length codes, RFC 8878
+// section 3.1.1.3.2.1.1
+var (
+	llBase = [36]uint32{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+		8192, 16384, 32768, 65536,
+	}
+	llBits = [36]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+		13, 14, 15, 16,
+	}
+	mlBase = [53]uint32{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+		4099, 8195, 16387, 32771, 65539,
+	}
+	mlBits = [53]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16,
+	}
+)
+
+func llCode(litLen uint32) uint8 {
+	if litLen >= 64 {
+		return uint8(bits.Len32(litLen) - 1 + 19)
+	}
+	code := uint8(len(llBase) - 1)
+	for llBase[code] > litLen {
+		code--
+	}
+	return code
+}
+
+func mlCode(matchLen uint32) uint8 {
+	if matchLen-3 >= 128 {
+		return uint8(bits.Len32(matchLen-3) - 1 + 36)
+	}
+	code := uint8(len(mlBase) - 1)
+	for mlBase[code] > matchLen {
+		code--
+	}
+	return code
+}
+
+// the predefined distributions, -1 is a probability below 1
+var (
+	llDefault = []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}
+	mlDefault = []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}
+	ofDefault = []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}
+
+	llTable = newFSETable(llDefault, 6)
+	mlTable = newFSETable(mlDefault, 6)
+	ofTable = newFSETable(ofDefault, 5)
+)
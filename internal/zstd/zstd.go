@@ -0,0 +1,202 @@
+// Package zstd writes Zstandard frames (RFC 8878) for the zstd content coding.
+// It only encodes and keeps it simple: hash chain matching inside each block,
+// Huffman coded literals when they are plain ASCII and FSE tables built per block
+// for the sequences. That beats gzip on JSON and is well short of the real library
+package zstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	magic = 0xFD2FB528
+	//the largest block the format allows, input is cut into blocks this size
+	blockSize = 128 << 10
+	//a 128KB window, matches never reach back past the block they are in
+	windowDescriptor = 7 << 3
+
+	blockRaw        = 0
+	blockCompressed = 2
+)
+
+var errClosed = errors.New("zstd: write to closed writer")
+
+// Writer compresses what is written to it into a single frame. Call Close to
+// finish the frame, Flush sends what has been written so far as a block
+type Writer struct {
+	w           io.Writer
+	buf         []byte //input not in a block yet
+	out         []byte
+	wroteHeader bool
+	closed      bool
+	err         error
+	enc         encoder
+}
+
+func NewWriter(w io.Writer) *Writer {
+	z := &Writer{buf: make([]byte, 0, blockSize)}
+	z.Reset(w)
+	return z
+}
+
+// Reset starts a new frame going to w, keeping the buffers
+func (z *Writer) Reset(w io.Writer) {
+	z.w = w
+	z.buf = z.buf[:0]
+	z.wroteHeader = false
+	z.closed = false
+	z.err = nil
+	z.enc.reps = initialReps
+}
+
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	if z.closed {
+		return 0, errClosed
+	}
+	n := len(p)
+	for len(p) > 0 {
+		take := min(len(p), blockSize-len(z.buf))
+		z.buf = append(z.buf, p[:take]...)
+		p = p[take:]
+		if len(z.buf) == blockSize {
+			if err := z.writeBlock(false); err != nil {
+				return n - len(p), err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Flush writes the buffered input as a block so the reader can decode everything
+// written so far
+func (z *Writer) Flush() error {
+	if z.err != nil {
+		return z.err
+	}
+	if z.closed || (len(z.buf) == 0 && z.wroteHeader) {
+		return nil
+	}
+	return z.writeBlock(false)
+}
+
+// Close writes the last block. It doesnt close the underlying writer
+func (z *Writer) Close() error {
+	if z.err != nil {
+		return z.err
+	}
+	if z.closed {
+		return nil
+	}
+	z.closed = true
+	return z.writeBlock(true)
+}
+
+func (z *Writer) writeBlock(last bool) error {
+	out := z.out[:0]
+	if !z.wroteHeader {
+		out = binary.LittleEndian.AppendUint32(out, magic)
+		//frame header descriptor with no content size, checksum or dictionary
+		out = append(out, 0, windowDescriptor)
+		z.wroteHeader = true
+	}
+	out = z.enc.appendBlock(out, z.buf, last)
+	z.buf = z.buf[:0]
+	z.out = out
+	_, z.err = z.w.Write(out)
+	return z.err
+}
+
+// encoder holds the scratch space for compressing blocks
+type encoder struct {
+	table    [1 << hashLog]int32 //position+1 of the last time a hash was seen
+	chain    []int32             //position+1 of the one before that, by position
+	literals []byte
+	seqs     []sequence
+	codes    []seqCodes
+	reps     [3]uint32 //repeat offsets, they carry on from block to block
+	huff     huffEncoder
+	bits     bitWriter
+}
+
+// appendBlock adds a block header and src compressed, or src as it is when
+// compressing doesnt make it smaller
+func (e *encoder) appendBlock(dst, src []byte, last bool) []byte {
+	var lastBit uint32
+	if last {
+		lastBit = 1
+	}
+	if len(src) > 0 {
+		start := len(dst)
+		reps := e.reps
+		dst = append(dst, 0, 0, 0)
+		dst = e.compress(dst, src)
+		if n := len(dst) - start - 3; n < len(src) {
+			putBlockHeader(dst[start:], lastBit|blockCompressed<<1|uint32(n)<<3)
+			return dst
+		}
+		//a raw block leaves the decoders repeat offsets alone
+		e.reps = reps
+		dst = dst[:start]
+	}
+	dst = append(dst, 0, 0, 0)
+	putBlockHeader(dst[len(dst)-3:], lastBit|blockRaw<<1|uint32(len(src))<<3)
+	return append(dst, src...)
+}
+
+func putBlockHeader(b []byte, h uint32) {
+	b[0] = byte(h)
+	b[1] = byte(h >> 8)
+	b[2] = byte(h >> 16)
+}
+
+func (e *encoder) compress(dst, src []byte) []byte {
+	e.parse(src)
+	dst = e.appendLiterals(dst, e.literals)
+	return e.appendSequences(dst, e.seqs)
+}
+
+// bitWriter builds the backwards bitstreams that Huffman and FSE data use. Bits go
+// in from the bottom up and the decoder reads them from the end
+type bitWriter struct {
+	out  []byte
+	acc  uint64
+	nacc uint
+}
+
+func (b *bitWriter) reset(dst []byte) {
+	b.out = dst
+	b.acc = 0
+	b.nacc = 0
+}
+
+// add writes the low n bits of v, n is at most 32
+func (b *bitWriter) add(v uint64, n uint) {
+	b.acc |= (v & (1<<n - 1)) << b.nacc
+	b.nacc += n
+	for b.nacc >= 8 {
+		b.out = append(b.out, byte(b.acc))
+		b.acc >>= 8
+		b.nacc -= 8
+	}
+}
+
+// pad fills out the last byte with zeros, for forward streams that have no end mark
+func (b *bitWriter) pad() []byte {
+	if b.nacc > 0 {
+		b.out = append(b.out, byte(b.acc))
+		b.acc = 0
+		b.nacc = 0
+	}
+	return b.out
+}
+
+// close adds the end mark the decoder looks for and pads out the last byte
+func (b *bitWriter) close() []byte {
+	b.add(1, 1)
+	return b.pad()
+}
@@ -0,0 +1,237 @@
+package zstd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// decode runs the reference decoder over b, the round trip tests skip without it
+func decode(t *testing.T, b []byte) []byte {
+	t.Helper()
+	path, err := exec.LookPath("zstd")
+	if err != nil {
+		t.Skip("zstd command not installed")
+	}
+	cmd := exec.Command(path, "-d", "-c")
+	cmd.Stdin = bytes.NewReader(b)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("zstd -d: %v: %s", err, stderr.String())
+	}
+	return out
+}
+
+func compress(in []byte) []byte {
+	var buf bytes.Buffer
+	z := NewWriter(&buf)
+	z.Write(in)
+	z.Close()
+	return buf.Bytes()
+}
+
+func movieJSON(n int) []byte {
+	var b strings.Builder
+	b.WriteString(`{"movies":[`)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `{"id":%d,"title":"Movie number %d","year":%d,"runtime":"%d mins","genres":["drama","comedy"],"version":%d}`,
+			i, i*7, 1950+i%70, 80+i%60, i%5)
+	}
+	b.WriteString(`]}`)
+	return []byte(b.String())
+}
+
+func TestEmptyFrame(t *testing.T) {
+	//magic, a descriptor with only the window, then an empty last raw block
+	want := []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x38, 0x01, 0x00, 0x00}
+	if got := compress(nil); !bytes.Equal(got, want) {
+		t.Errorf("got % x, want % x", got, want)
+	}
+}
+
+func TestIncompressible(t *testing.T) {
+	in := make([]byte, 200000)
+	rand.New(rand.NewSource(1)).Read(in)
+	//one full raw block, one last raw block and 6 bytes of frame header
+	if got := compress(in); len(got) != len(in)+6+2*3 {
+		t.Errorf("random data came out %d bytes, want %d", len(got), len(in)+12)
+	}
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	z := NewWriter(&bytes.Buffer{})
+	z.Close()
+	if _, err := z.Write([]byte("x")); !errors.Is(err, errClosed) {
+		t.Errorf("got %v, want errClosed", err)
+	}
+	if err := z.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
+
+// the example from RFC 8878 section 4.2.1.1
+func TestHuffmanCodes(t *testing.T) {
+	var h huffEncoder
+	copy(h.lengths[:], []uint8{1, 2, 3, 0, 4, 4})
+	if maxLen := h.assignCodes(5); maxLen != 4 {
+		t.Errorf("max length %d, want 4", maxLen)
+	}
+	want := []uint16{0b1, 0b01, 0b001, 0, 0b0000, 0b0001}
+	for s, code := range want {
+		if h.lengths[s] > 0 && h.codes[s] != code {
+			t.Errorf("symbol %d got code %b, want %b", s, h.codes[s], code)
+		}
+	}
+}
+
+func TestHuffmanLengthLimit(t *testing.T) {
+	//fibonacci counts make the deepest plain Huffman tree there is
+	var h huffEncoder
+	a, b := 1, 1
+	for s := 0; s < 30; s++ {
+		h.counts[s] = a
+		a, b = b, a+b
+	}
+	if maxLen := h.buildCodes(29); maxLen > maxHuffBits {
+		t.Errorf("longest code %d bits, want at most %d", maxLen, maxHuffBits)
+	}
+	kraft := 0
+	for s := 0; s < 30; s++ {
+		kraft += 1 << (maxHuffBits - h.lengths[s])
+	}
+	if kraft != 1<<maxHuffBits {
+		t.Errorf("code lengths dont make a complete tree")
+	}
+}
+
+func TestRepeatOffsets(t *testing.T) {
+	e := encoder{reps: initialReps}
+	tests := []struct {
+		offset, litLen uint32
+		want           uint32
+		reps           [3]uint32
+	}{
+		{100, 5, 103, [3]uint32{100, 1, 4}},
+		{100, 5, 1, [3]uint32{100, 1, 4}},
+		{4, 2, 3, [3]uint32{4, 100, 1}},
+		{100, 0, 1, [3]uint32{100, 4, 1}},
+		{1, 0, 2, [3]uint32{1, 100, 4}},
+		{100, 3, 2, [3]uint32{100, 1, 4}},
+		{99, 0, 3, [3]uint32{99, 100, 1}},
+		//the last offset again with no literals has no repeat value
+		{99, 0, 102, [3]uint32{99, 99, 100}},
+	}
+	for i, tt := range tests {
+		if got := e.offsetValue(tt.offset, tt.litLen); got != tt.want || e.reps != tt.reps {
+			t.Fatalf("step %d: got %d %v, want %d %v", i, got, e.reps, tt.want, tt.reps)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := [][]int{
+		{1, 1},
+		{1000, 1, 1, 1, 0, 1},
+		{5, 0, 0, 7, 2, 9, 1},
+	}
+	for _, counts := range tests {
+		total := 0
+		for _, c := range counts {
+			total += c
+		}
+		norm := normalize(counts, total, 6)
+		sum := 0
+		for s, p := range norm {
+			if (counts[s] == 0) != (p == 0) || p < 0 {
+				t.Errorf("%v normalized to %v", counts, norm)
+			}
+			sum += int(p)
+		}
+		if sum != 64 {
+			t.Errorf("%v normalized to %v, adds up to %d", counts, norm, sum)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 300000)
+	rng.Read(random)
+	words := []string{"the", "movie", "runtime", "drama", "é", "日本語", "\n", "{", "}", `"`, ":", "1", "\x00"}
+	var text strings.Builder
+	for text.Len() < 400000 {
+		text.WriteString(words[rng.Intn(len(words))])
+	}
+
+	tests := []struct {
+		name string
+		in   []byte
+	}{
+		{"empty", nil},
+		{"one byte", []byte("a")},
+		{"short", []byte("hello hello hello hello world")},
+		{"movies", movieJSON(5000)},
+		{"small movies", movieJSON(20)},
+		{"random", random},
+		{"non ascii", []byte(text.String())},
+		{"zeros", make([]byte, 500000)},
+		{"overlapping", []byte(strings.Repeat("ab", 1000))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decode(t, compress(tt.in)); !bytes.Equal(got, tt.in) {
+				t.Errorf("round trip gave %d bytes back, want %d", len(got), len(tt.in))
+			}
+		})
+	}
+}
+
+// writes in odd sizes, flushes and writer reuse all have to give a valid frame
+func TestStreaming(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	in := movieJSON(3000)
+	z := NewWriter(nil)
+	for i := 0; i < 5; i++ {
+		var buf bytes.Buffer
+		z.Reset(&buf)
+		for p := in; len(p) > 0; {
+			n := min(len(p), 1+rng.Intn(100000))
+			if _, err := z.Write(p[:n]); err != nil {
+				t.Fatal(err)
+			}
+			p = p[n:]
+			if rng.Intn(3) == 0 {
+				if err := z.Flush(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if err := z.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got := decode(t, buf.Bytes()); !bytes.Equal(got, in) {
+			t.Fatalf("pass %d: round trip gave %d bytes back, want %d", i, len(got), len(in))
+		}
+	}
+}
+
+func BenchmarkWriter(b *testing.B) {
+	in := movieJSON(5000)
+	z := NewWriter(nil)
+	b.SetBytes(int64(len(in)))
+	for i := 0; i < b.N; i++ {
+		z.Reset(io.Discard)
+		z.Write(in)
+		z.Close()
+	}
+}
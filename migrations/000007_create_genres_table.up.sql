@@ -0,0 +1,75 @@
+CREATE TABLE IF NOT EXISTS genres (
+    id bigserial PRIMARY KEY,
+    slug text UNIQUE NOT NULL,
+    name text NOT NULL
+);
+
+-- Seed the canonical genre list.
+INSERT INTO genres (slug, name)
+VALUES
+    ('action', 'Action'),
+    ('adventure', 'Adventure'),
+    ('animation', 'Animation'),
+    ('biography', 'Biography'),
+    ('comedy', 'Comedy'),
+    ('crime', 'Crime'),
+    ('documentary', 'Documentary'),
+    ('drama', 'Drama'),
+    ('family', 'Family'),
+    ('fantasy', 'Fantasy'),
+    ('history', 'History'),
+    ('horror', 'Horror'),
+    ('music', 'Music'),
+    ('musical', 'Musical'),
+    ('mystery', 'Mystery'),
+    ('romance', 'Romance'),
+    ('sci-fi', 'Science Fiction'),
+    ('sport', 'Sport'),
+    ('thriller', 'Thriller'),
+    ('war', 'War'),
+    ('western', 'Western')
+ON CONFLICT (slug) DO NOTHING;
+
+-- Rewrite the free-text arrays into slugs. Values are lowercased and have their
+-- whitespace turned into hyphens, then known spellings are mapped onto a canonical slug.
+-- The aliases are the same as genreAliases in internal/data/genres.go, which applies
+-- them to everything written or filtered on from here on.
+WITH aliases (alias, slug) AS (
+    VALUES
+        ('science-fiction', 'sci-fi'),
+        ('scifi', 'sci-fi'),
+        ('sf', 'sci-fi'),
+        ('rom-com', 'romance'),
+        ('romcom', 'romance'),
+        ('romantic', 'romance'),
+        ('animated', 'animation'),
+        ('cartoon', 'animation'),
+        ('documentaries', 'documentary'),
+        ('doc', 'documentary'),
+        ('biopic', 'biography'),
+        ('historical', 'history'),
+        ('sports', 'sport'),
+        ('thrillers', 'thriller'),
+        ('musicals', 'musical')
+),
+normalized AS (
+    SELECT movies.id, array_agg(DISTINCT coalesce(aliases.slug, cleaned.genre)) AS genres
+    FROM movies
+    CROSS JOIN LATERAL unnest(movies.genres) AS raw(genre)
+    CROSS JOIN LATERAL (SELECT regexp_replace(lower(trim(raw.genre)), '\s+', '-', 'g') AS genre) AS cleaned
+    LEFT JOIN aliases ON aliases.alias = cleaned.genre
+    GROUP BY movies.id
+)
+UPDATE movies
+SET genres = normalized.genres
+FROM normalized
+WHERE movies.id = normalized.id;
+
+-- Only the curated list above is seeded. Anything that didn't map onto it is dropped
+-- from movies that still have a real genre left. Movies with nothing but unknown
+-- genres keep them, they fail validation on their next edit until someone picks one
+-- from the list.
+UPDATE movies
+SET genres = array(SELECT genre FROM unnest(movies.genres) AS genre WHERE genre IN (SELECT slug FROM genres))
+WHERE movies.genres && array(SELECT slug FROM genres)
+AND NOT movies.genres <@ array(SELECT slug FROM genres);
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS movies_external_ids_idx;
+
+ALTER TABLE movies DROP COLUMN IF EXISTS external_ids;
+ALTER TABLE movies DROP COLUMN IF EXISTS certifications;
+ALTER TABLE movies DROP COLUMN IF EXISTS spoken_languages;
+ALTER TABLE movies DROP COLUMN IF EXISTS original_language;
+ALTER TABLE movies DROP COLUMN IF EXISTS original_title;
+ALTER TABLE movies DROP COLUMN IF EXISTS synopsis;
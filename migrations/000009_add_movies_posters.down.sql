@@ -0,0 +1,2 @@
+ALTER TABLE movies DROP COLUMN IF EXISTS poster_thumbnail_url;
+ALTER TABLE movies DROP COLUMN IF EXISTS poster_url;
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS movie_release_dates;
+
+-- This fails if any announced titles with future years are still in the table.
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_year_check;
+
+ALTER TABLE movies ADD CONSTRAINT movies_year_check CHECK (year BETWEEN 1888 AND date_part('year', now()));
@@ -0,0 +1,14 @@
+-- Announced titles can carry a year up to a decade ahead.
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_year_check;
+
+ALTER TABLE movies ADD CONSTRAINT movies_year_check CHECK (year BETWEEN 1888 AND date_part('year', now()) + 10);
+
+CREATE TABLE IF NOT EXISTS movie_release_dates (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    region text NOT NULL CHECK (region ~ '^[A-Z]{2}$'),
+    release_type text NOT NULL CHECK (release_type IN ('theatrical', 'streaming', 'physical')),
+    release_date date NOT NULL,
+    PRIMARY KEY (movie_id, region, release_type)
+);
+
+CREATE INDEX IF NOT EXISTS movie_release_dates_date_idx ON movie_release_dates (release_date, region);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS series (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    title text NOT NULL,
+    description text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1
+);
+
+-- The position constraint is deferrable so that shifting members along by one
+-- is only checked once the whole UPDATE has finished.
+CREATE TABLE IF NOT EXISTS series_movies (
+    series_id bigint NOT NULL REFERENCES series ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    position integer NOT NULL CHECK (position > 0),
+    PRIMARY KEY (series_id, movie_id),
+    CONSTRAINT series_movies_position_key UNIQUE (series_id, position) DEFERRABLE INITIALLY IMMEDIATE
+);
+
+CREATE INDEX IF NOT EXISTS series_movies_movie_id_idx ON series_movies (movie_id);
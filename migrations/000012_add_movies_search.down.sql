@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS movies_search_vector_idx;
+DROP TRIGGER IF EXISTS movies_search_vector_update ON movies;
+DROP FUNCTION IF EXISTS movies_search_vector_update();
+ALTER TABLE movies DROP COLUMN IF EXISTS search_vector;
+DROP FUNCTION IF EXISTS movie_search_vector(regconfig, text, text, text);
+DROP TABLE IF EXISTS search_config;
@@ -0,0 +1,32 @@
+-- one row holding the text search config the search vectors are built with,
+-- the api sets it from -search-language on startup
+CREATE TABLE IF NOT EXISTS search_config (
+    id boolean PRIMARY KEY DEFAULT true CHECK (id),
+    language regconfig NOT NULL DEFAULT 'english'
+);
+INSERT INTO search_config DEFAULT VALUES ON CONFLICT DO NOTHING;
+
+-- title weighs most, then the synopsis and original title
+CREATE OR REPLACE FUNCTION movie_search_vector(language regconfig, title text, synopsis text, original_title text)
+RETURNS tsvector AS $$
+    SELECT setweight(to_tsvector(language, title), 'A') ||
+        setweight(to_tsvector(language, synopsis), 'B') ||
+        setweight(to_tsvector(language, original_title), 'B')
+$$ LANGUAGE sql IMMUTABLE;
+
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS search_vector tsvector NOT NULL DEFAULT '';
+
+CREATE OR REPLACE FUNCTION movies_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    NEW.search_vector := movie_search_vector((SELECT language FROM search_config), NEW.title, NEW.synopsis, NEW.original_title);
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER movies_search_vector_update
+    BEFORE INSERT OR UPDATE OF title, synopsis, original_title ON movies
+    FOR EACH ROW EXECUTE FUNCTION movies_search_vector_update();
+
+UPDATE movies SET search_vector = movie_search_vector((SELECT language FROM search_config), title, synopsis, original_title);
+
+CREATE INDEX IF NOT EXISTS movies_search_vector_idx ON movies USING GIN (search_vector);
@@ -0,0 +1,4 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- gist rather than gin so ORDER BY distance LIMIT n can walk the index
+CREATE INDEX IF NOT EXISTS movies_title_trgm_idx ON movies USING GIST (title gist_trgm_ops);
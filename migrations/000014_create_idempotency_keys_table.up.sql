@@ -0,0 +1,16 @@
+-- responses to POSTs sent with an Idempotency-Key, replayed when the request is retried.
+-- user_id is 0 for anonymous requests like sign up, so there is no foreign key
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id bigint NOT NULL,
+    key text NOT NULL,
+    request_hash bytea NOT NULL,
+    -- NULL until the first request finishes
+    status_code integer,
+    response_headers jsonb NOT NULL DEFAULT '{}',
+    response_body bytea NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expires_at timestamp(0) with time zone NOT NULL,
+    PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
@@ -0,0 +1,4 @@
+-- soft delete, NULL for live movies. Deleted ones sit in the trash until purged
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS movies_deleted_at_idx ON movies (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,55 @@
+-- a snapshot of a movie for every version it has been at. No foreign key so the
+-- history outlives a purge. user_id comes from greenlight.user_id, which the api sets
+-- in the same transaction as the write, NULL for changes made outside the api
+CREATE TABLE IF NOT EXISTS movie_revisions (
+    id bigserial PRIMARY KEY,
+    movie_id bigint NOT NULL,
+    version integer NOT NULL,
+    -- insert, update, delete, restore or purge
+    operation text NOT NULL,
+    snapshot jsonb NOT NULL,
+    user_id bigint,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS movie_revisions_movie_id_idx ON movie_revisions (movie_id, id);
+
+CREATE OR REPLACE FUNCTION movies_record_revision() RETURNS trigger AS $$
+DECLARE
+    actor bigint := NULLIF(current_setting('greenlight.user_id', true), '')::bigint;
+    op text;
+    rev movies;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        op := 'insert';
+        rev := NEW;
+    ELSIF TG_OP = 'DELETE' THEN
+        op := 'purge';
+        rev := OLD;
+    ELSE
+        -- reindexing search vectors and the like dont bump the version, nothing to record
+        IF NEW.version = OLD.version THEN
+            RETURN NULL;
+        END IF;
+        op := CASE
+            WHEN OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN 'delete'
+            WHEN OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL THEN 'restore'
+            ELSE 'update'
+        END;
+        rev := NEW;
+    END IF;
+
+    INSERT INTO movie_revisions (movie_id, version, operation, snapshot, user_id)
+    VALUES (rev.id, rev.version, op, to_jsonb(rev) - 'search_vector', actor);
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER movies_record_revision
+    AFTER INSERT OR UPDATE OR DELETE ON movies
+    FOR EACH ROW EXECUTE FUNCTION movies_record_revision();
+
+-- movies from before this migration start off with their current state
+INSERT INTO movie_revisions (movie_id, version, operation, snapshot, created_at)
+SELECT id, version, 'insert', to_jsonb(movies) - 'search_vector', created_at
+FROM movies;
@@ -0,0 +1,31 @@
+-- security relevant events, logins, tokens, activations and permissions. Rows are
+-- never changed or removed, the trigger below makes sure of it. user_id is who the
+-- event is about, NULL when we dont know, like a login for an unknown email
+CREATE TABLE IF NOT EXISTS audit_events (
+    id bigserial PRIMARY KEY,
+    type text NOT NULL,
+    user_id bigint,
+    ip text NOT NULL DEFAULT '',
+    user_agent text NOT NULL DEFAULT '',
+    request_id text NOT NULL DEFAULT '',
+    details jsonb NOT NULL DEFAULT '{}',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_created_at_idx ON audit_events (created_at);
+CREATE INDEX IF NOT EXISTS audit_events_user_id_idx ON audit_events (user_id);
+
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append only';
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_append_only
+    BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_events
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
+
+-- reading the audit log is for admins only, nobody gets it by default
+INSERT INTO permissions (code)
+SELECT 'audit:read'
+WHERE NOT EXISTS (SELECT 1 FROM permissions WHERE code = 'audit:read');
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS watchlist;
+DROP TABLE IF EXISTS movie_ratings;
+DROP TABLE IF EXISTS movie_credits;
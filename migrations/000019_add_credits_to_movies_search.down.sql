@@ -0,0 +1,21 @@
+DROP TRIGGER IF EXISTS movie_credits_search_vector_update ON movie_credits;
+DROP FUNCTION IF EXISTS movie_credits_search_vector_update();
+DROP FUNCTION IF EXISTS refresh_movie_search_vector(bigint);
+DROP FUNCTION IF EXISTS movie_search_vector(regconfig, text, text, text, text);
+DROP FUNCTION IF EXISTS movie_credit_names(bigint);
+
+CREATE OR REPLACE FUNCTION movie_search_vector(language regconfig, title text, synopsis text, original_title text)
+RETURNS tsvector AS $$
+    SELECT setweight(to_tsvector(language, title), 'A') ||
+        setweight(to_tsvector(language, synopsis), 'B') ||
+        setweight(to_tsvector(language, original_title), 'B')
+$$ LANGUAGE sql IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION movies_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    NEW.search_vector := movie_search_vector((SELECT language FROM search_config), NEW.title, NEW.synopsis, NEW.original_title);
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+UPDATE movies SET search_vector = movie_search_vector((SELECT language FROM search_config), title, synopsis, original_title);
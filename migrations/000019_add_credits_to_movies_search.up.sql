@@ -0,0 +1,48 @@
+-- cast and crew names go in the search vector at weight B, next to the synopsis
+CREATE OR REPLACE FUNCTION movie_credit_names(movie_id bigint)
+RETURNS text AS $$
+    SELECT string_agg(name, ' ' ORDER BY position) FROM movie_credits WHERE movie_credits.movie_id = $1
+$$ LANGUAGE sql STABLE;
+
+DROP FUNCTION IF EXISTS movie_search_vector(regconfig, text, text, text);
+
+CREATE OR REPLACE FUNCTION movie_search_vector(language regconfig, title text, synopsis text, original_title text, credits text)
+RETURNS tsvector AS $$
+    SELECT setweight(to_tsvector(language, title), 'A') ||
+        setweight(to_tsvector(language, synopsis), 'B') ||
+        setweight(to_tsvector(language, original_title), 'B') ||
+        setweight(to_tsvector(language, coalesce(credits, '')), 'B')
+$$ LANGUAGE sql IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION movies_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    NEW.search_vector := movie_search_vector((SELECT language FROM search_config), NEW.title, NEW.synopsis, NEW.original_title, movie_credit_names(NEW.id));
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION refresh_movie_search_vector(movie_id bigint) RETURNS void AS $$
+    UPDATE movies
+    SET search_vector = movie_search_vector((SELECT language FROM search_config), title, synopsis, original_title, movie_credit_names(id))
+    WHERE id = $1
+$$ LANGUAGE sql;
+
+-- credits are written separately from the movie, so changing them rebuilds its vector.
+-- An update that moves a credit to another movie rebuilds both
+CREATE OR REPLACE FUNCTION movie_credits_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP <> 'DELETE' THEN
+        PERFORM refresh_movie_search_vector(NEW.movie_id);
+    END IF;
+    IF TG_OP = 'DELETE' OR (TG_OP = 'UPDATE' AND OLD.movie_id <> NEW.movie_id) THEN
+        PERFORM refresh_movie_search_vector(OLD.movie_id);
+    END IF;
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER movie_credits_search_vector_update
+    AFTER INSERT OR UPDATE OR DELETE ON movie_credits
+    FOR EACH ROW EXECUTE FUNCTION movie_credits_search_vector_update();
+
+UPDATE movies SET search_vector = movie_search_vector((SELECT language FROM search_config), title, synopsis, original_title, movie_credit_names(id));